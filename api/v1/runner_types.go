@@ -1,23 +1,318 @@
 package v1
 
 import (
+	autoscalingV2 "k8s.io/api/autoscaling/v2"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // RunnerSpec defines the desired state of Runner
+// +kubebuilder:validation:XValidation:rule="has(self.image) || has(self.runnerClassName)",message="image is required without runnerClassName"
+// +kubebuilder:validation:XValidation:rule="!has(self.pools) || ((!has(self.workloadKind) || self.workloadKind == 'Deployment') && (!has(self.cache) || !has(self.cache.work)))",message="pools require workloadKind Deployment"
+// +kubebuilder:validation:XValidation:rule="!has(self.schedules) || has(self.pools)",message="schedules require pools"
+// +kubebuilder:validation:XValidation:rule="!has(self.pools) || !has(self.rollout) || !has(self.rollout.canary)",message="rollout.canary cannot be used with pools"
+// +kubebuilder:validation:XValidation:rule="!has(self.workloadKind) || self.workloadKind == 'StatefulSet' || !has(self.cache) || !has(self.cache.work)",message="cache.work requires workloadKind StatefulSet"
 type RunnerSpec struct {
 	// Image using by self-hosted runner
-	Image string `json:"image"`
+	// Defaults to the image of the runner class.
+	// +optional
+	Image string `json:"image,omitempty"`
+	// Name of the cluster-scoped RunnerClass providing defaults of the runner
+	// +optional
+	RunnerClassName string `json:"runnerClassName,omitempty"`
 	// GitHub Repository Name to use runner
+	// Required by Runner, and filled by the RunnerSet in templates of RunnerSets.
 	// +kubebuilder:validation:XValidation:rule="self.find('[^/]+/[^/]+') != ''",message="must be /[^\\/]+\\/[^\\/]+/"
-	Repository string `json:"repository"`
+	// +optional
+	Repository string `json:"repository,omitempty"`
+	// Version of GitHub Actions runner, either a pinned version such as 2.321.0 or latest
+	// Defaults to the runner version of the controller.
+	// +kubebuilder:validation:Pattern=`^(latest|[0-9]+\.[0-9]+\.[0-9]+)$`
+	// +optional
+	RunnerVersion string `json:"runnerVersion,omitempty"`
+	// Disable self-hosted runner automatic update to the latest released version
+	// Defaults to the disableupdate of the controller.
+	// +optional
+	DisableUpdate *bool `json:"disableUpdate,omitempty"`
+	// CPU architecture of nodes running self-hosted runner
+	// +kubebuilder:validation:Enum=amd64;arm64
+	// +kubebuilder:default=amd64
+	// +optional
+	Architecture string `json:"architecture,omitempty"`
 	// Selects a key of a GitHub Token secret in the runner's namespace
 	TokenSecretKeyRef    *v1.SecretKeySelector `json:"tokenSecretKeyRef,omitempty"`
 	AppSecretRef         *v1.SecretEnvSource   `json:"appSecretRef,omitempty"`
 	Template             Template              `json:"template,omitempty"`
+	Build                Build                 `json:"build,omitempty"`
 	BuilderContainerSpec BuilderContainerSpec  `json:"builderContainerSpec,omitempty"`
 	RunnerContainerSpec  RunnerContainerSpec   `json:"runnerContainerSpec,omitempty"`
+	// Seconds a runner pod waits for its current job to finish before it is killed on termination
+	// Set it up to the timeout of jobs so that rolling updates never fail running jobs, which can be up to 5 days on self-hosted runners.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=432000
+	// +kubebuilder:default=30
+	// +optional
+	TerminationGracePeriodSeconds *int64 `json:"terminationGracePeriodSeconds,omitempty"`
+	// Kind of the workload running runner pods
+	// With StatefulSet, runners get stable names from ordinals of pods and replace their previous registrations.
+	// Defaults to StatefulSet if cache.work is set, and Deployment otherwise.
+	// +kubebuilder:validation:Enum=Deployment;StatefulSet
+	// +optional
+	WorkloadKind string `json:"workloadKind,omitempty"`
+	// Number of runner pods of the Deployment or the StatefulSet when pools are not used
+	// The workload is left to be scaled by hand if unset.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Replicas *int32 `json:"replicas,omitempty"`
+	// Claims of volumes created per runner pod, which can be mounted by runnerContainerSpec.volumeMounts
+	// Only used with StatefulSet, and cannot be updated after the StatefulSet is created.
+	// +optional
+	VolumeClaimTemplates []v1.PersistentVolumeClaim `json:"volumeClaimTemplates,omitempty"`
+	// Volumes persisting the tool cache and the work folder across runner pods
+	// +optional
+	Cache Cache `json:"cache,omitempty"`
+	// Strategy rolling out changes of runner pods
+	// +optional
+	Rollout Rollout `json:"rollout,omitempty"`
+	// Pools of runners sharing the runner image, each run by its own Deployment named <name>-runner-<pool>
+	// Without pools, runners are run by a single Deployment named <name>-runner.
+	// +listType=map
+	// +listMapKey=name
+	// +optional
+	Pools []Pool `json:"pools,omitempty"`
+	// Windows overriding the number of runners of pools, such as to scale them down at night
+	// When windows overlap, the first schedule in the list applies to each pool.
+	// +listType=map
+	// +listMapKey=name
+	// +optional
+	Schedules []CapacitySchedule `json:"schedules,omitempty"`
+}
+
+// CapacitySchedule defines bounds of the number of runners of pools in a window
+type CapacitySchedule struct {
+	// Name of the schedule, reported in status.activeSchedules
+	Name string `json:"name"`
+	// Cron expression at which the window starts, such as "0 9 * * 1-5"
+	Schedule string `json:"schedule"`
+	// Time zone of schedule, such as Asia/Tokyo
+	// +kubebuilder:default=UTC
+	// +optional
+	TimeZone string `json:"timeZone,omitempty"`
+	// Length of the window
+	Duration metaV1.Duration `json:"duration"`
+	// Names of pools the schedule applies to, defaulting to all pools
+	// +optional
+	Pools []string `json:"pools,omitempty"`
+	// Lower limit of the number of runners of each pool during the window
+	// A HorizontalPodAutoscaler keeps at least 1 runner, and pools with minReplicas 0 keep this many runners warm.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MinReplicas *int32 `json:"minReplicas,omitempty"`
+	// Upper limit of the number of runners of each pool during the window
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxReplicas *int32 `json:"maxReplicas,omitempty"`
+}
+
+// RunnerLabel is a label registered to GitHub Actions runner, which is passed in a comma-separated list
+// +kubebuilder:validation:Pattern=`^[^,\s]+$`
+type RunnerLabel string
+
+// Pool defines runners run by a Deployment of their own
+type Pool struct {
+	// Name of the pool
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	// +kubebuilder:validation:MaxLength=15
+	Name string `json:"name"`
+	// Number of runners of the pool, ignored with autoscaling
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:default=1
+	// +optional
+	Replicas *int32 `json:"replicas,omitempty"`
+	// Scales runners of the pool by a HorizontalPodAutoscaler instead of replicas
+	// +optional
+	Autoscaling *Autoscaling `json:"autoscaling,omitempty"`
+	// Additional labels of runners of the pool, which jobs select by runs-on
+	// +optional
+	Labels []RunnerLabel `json:"labels,omitempty"`
+	// Compute resources of runner containers of the pool, in place of runnerContainerSpec.resources
+	// +optional
+	Resources *v1.ResourceRequirements `json:"resources,omitempty"`
+	// Node selector of runner pods of the pool
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+}
+
+// Autoscaling defines how runners of a pool are scaled
+type Autoscaling struct {
+	// Lower limit of the number of runners
+	// With 0, the controller scales the pool by queued jobs matching its labels instead of a HorizontalPodAutoscaler,
+	// waking it up from zero and scaling it back down after idleTimeout.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:default=1
+	// +optional
+	MinReplicas *int32 `json:"minReplicas,omitempty"`
+	// Upper limit of the number of runners
+	// +kubebuilder:validation:Minimum=1
+	MaxReplicas int32 `json:"maxReplicas"`
+	// Metrics to scale runners by, such as github_actions_runner_busy exposed with --enable-runner-metrics
+	// Defaults to 80% of CPU utilization. Not used with minReplicas 0.
+	// +optional
+	Metrics []autoscalingV2.MetricSpec `json:"metrics,omitempty"`
+	// Period without queued or running jobs after which runners scaled by queued jobs are scaled back down
+	// +kubebuilder:default="10m"
+	// +optional
+	IdleTimeout *metaV1.Duration `json:"idleTimeout,omitempty"`
+	// Windows in which runners scaled by queued jobs are kept warm even while idle
+	// +optional
+	WarmPools []WarmPool `json:"warmPools,omitempty"`
+}
+
+// WarmPool defines a window in which idle runners are kept
+type WarmPool struct {
+	// Cron expression at which the window starts, such as "0 9 * * 1-5"
+	Schedule string `json:"schedule"`
+	// Time zone of schedule, such as Asia/Tokyo
+	// +kubebuilder:default=UTC
+	// +optional
+	TimeZone string `json:"timeZone,omitempty"`
+	// Length of the window
+	Duration metaV1.Duration `json:"duration"`
+	// Number of runners kept during the window
+	// +kubebuilder:validation:Minimum=1
+	Replicas int32 `json:"replicas"`
+}
+
+// Cache defines volumes persisting the tool cache and the work folder across runner pods
+type Cache struct {
+	// Tool cache shared by all runner pods in a ReadWriteMany PersistentVolumeClaim, mounted at RUNNER_TOOL_CACHE
+	// +optional
+	ToolCache *CacheVolume `json:"toolCache,omitempty"`
+	// Work folder kept per runner pod in a PersistentVolumeClaim
+	// Runner pods are run by a StatefulSet instead of a Deployment to give each of them its own claim.
+	// +optional
+	Work *CacheVolume `json:"work,omitempty"`
+}
+
+// CacheVolume defines a PersistentVolumeClaim of cache and how it is pruned
+type CacheVolume struct {
+	// Requested size of the PersistentVolumeClaim
+	Size resource.Quantity `json:"size"`
+	// Name of the StorageClass of the PersistentVolumeClaim
+	// +optional
+	StorageClassName *string `json:"storageClassName,omitempty"`
+	// Entries not used for this long are pruned hourly
+	// The tool cache is pruned by a CronJob of the Runner, and the work folder by each runner pod while no job is running.
+	// +optional
+	MaxAge *metaV1.Duration `json:"maxAge,omitempty"`
+}
+
+// Rollout defines how changes of runner pods are rolled out
+type Rollout struct {
+	// Brings up canary runners with the change first, and promotes it only after they succeed in jobs
+	// Without canary, changes are rolled out to all runners at once.
+	// +optional
+	Canary *Canary `json:"canary,omitempty"`
+}
+
+// Canary defines the canary runners and when the change is promoted or rolled back
+type Canary struct {
+	// Number of canary runners
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=1
+	// +optional
+	Replicas int32 `json:"replicas,omitempty"`
+	// Number of jobs the canary runners must succeed in before the change is promoted
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=3
+	// +optional
+	SuccessfulJobs int32 `json:"successfulJobs,omitempty"`
+	// Percentage of failed jobs among finished jobs of the canary runners above which the change is rolled back
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +kubebuilder:default=50
+	// +optional
+	MaxFailurePercentage int32 `json:"maxFailurePercentage,omitempty"`
+}
+
+// Build defines how the runner image is built
+type Build struct {
+	// Backend building the runner image
+	// With none, image is used as is and must already contain the runner binary and GitHub Actions runner.
+	// Defaults to the builder of the controller.
+	// +kubebuilder:validation:Enum=kaniko;buildkit;none
+	// +optional
+	Builder string `json:"builder,omitempty"`
+	// Registry used to push and pull the runner image
+	// Unset fields fall back to the defaults of the controller.
+	// +optional
+	Registry Registry `json:"registry,omitempty"`
+	// Interval to check whether the tag of the base image has moved
+	// The runner image is rebuilt and rolled out when the digest of the base image changes.
+	// +optional
+	RefreshInterval *metaV1.Duration `json:"refreshInterval,omitempty"`
+	// Seconds a build may run before the pod building it is deleted
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	TimeoutSeconds *int64 `json:"timeoutSeconds,omitempty"`
+	// Preset of compute resources of builder container, used where builderContainerSpec.resources does not set them
+	// +kubebuilder:validation:Enum=small;medium;large
+	// +optional
+	ResourcePreset string `json:"resourcePreset,omitempty"`
+	// Options of kaniko building the runner image
+	// +optional
+	Kaniko Kaniko `json:"kaniko,omitempty"`
+	// Signs the runner image and attaches its SBOM after it is pushed
+	// +optional
+	Signing *Signing `json:"signing,omitempty"`
+}
+
+// Signing defines how the runner image is signed
+type Signing struct {
+	// Secret in the runner's namespace holding the cosign private key as cosign.key, and its password as cosign.password if encrypted
+	KeySecretRef v1.LocalObjectReference `json:"keySecretRef"`
+	// Format of the SBOM attested to the runner image
+	// +kubebuilder:validation:Enum=spdx-json;cyclonedx-json
+	// +kubebuilder:default=spdx-json
+	// +optional
+	SBOMFormat string `json:"sbomFormat,omitempty"`
+	// Upload signatures to the Rekor transparency log
+	// +optional
+	TransparencyLog bool `json:"transparencyLog,omitempty"`
+}
+
+// Kaniko defines options of kaniko
+type Kaniko struct {
+	// Repository to store cached layers in, such as <registry>/cache to reuse layers across runners
+	// Defaults to <destination>/cache of kaniko.
+	// +optional
+	CacheRepository string `json:"cacheRepository,omitempty"`
+	// Lifetime of cached layers
+	// +optional
+	CacheTTL *metaV1.Duration `json:"cacheTTL,omitempty"`
+	// How kaniko takes filesystem snapshots
+	// +kubebuilder:validation:Enum=full;redo;time
+	// +optional
+	SnapshotMode string `json:"snapshotMode,omitempty"`
+	// Additional arguments passed to kaniko
+	// +optional
+	ExtraArgs []string `json:"extraArgs,omitempty"`
+}
+
+// Registry defines credentials and TLS configuration for the registry
+type Registry struct {
+	// Secret of type kubernetes.io/dockerconfigjson in the runner's namespace
+	// It is mounted into the builder container at /kaniko/.docker and used as imagePullSecrets of runner pods.
+	// +optional
+	DockerConfigSecretRef *v1.LocalObjectReference `json:"dockerConfigSecretRef,omitempty"`
+	// Push to the registry over plain HTTP or without verifying its certificate
+	// Defaults to the registry-insecure of the controller.
+	// +optional
+	Insecure *bool `json:"insecure,omitempty"`
+	// Selects a key of a ConfigMap in the runner's namespace holding the PEM encoded CA certificate of the registry
+	// +optional
+	CACertificateConfigMapKeyRef *v1.ConfigMapKeySelector `json:"caCertificateConfigMapKeyRef,omitempty"`
 }
 
 // Template defines the pod template generated by runner
@@ -84,12 +379,139 @@ type RunnerContainerSpec struct {
 	// +patchMergeKey=mountPath
 	// +patchStrategy=merge
 	VolumeMounts []v1.VolumeMount `json:"volumeMounts,omitempty" patchStrategy:"merge" patchMergeKey:"mountPath" protobuf:"bytes,9,rep,name=volumeMounts"`
+	// Scripts run by the runner around each job, such as to wipe the work folder or to warm caches
+	// +optional
+	Hooks Hooks `json:"hooks,omitempty"`
+}
+
+// Hooks defines scripts run by the runner around each job
+type Hooks struct {
+	// Script run before each job, wired to ACTIONS_RUNNER_HOOK_JOB_STARTED
+	// +optional
+	JobStarted *Hook `json:"jobStarted,omitempty"`
+	// Script run after each job, wired to ACTIONS_RUNNER_HOOK_JOB_COMPLETED
+	// +optional
+	JobCompleted *Hook `json:"jobCompleted,omitempty"`
+}
+
+// Hook defines a bash script run by the runner and how its failure is handled
+type Hook struct {
+	// Selects a key of a ConfigMap in the runner's namespace holding the bash script
+	ConfigMapKeyRef v1.ConfigMapKeySelector `json:"configMapKeyRef"`
+	// Seconds the script may run before it is killed and regarded as failed
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=300
+	// +optional
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+	// Whether the failure of the script fails the job, or is only logged
+	// +kubebuilder:validation:Enum=Fail;Ignore
+	// +kubebuilder:default=Fail
+	// +optional
+	FailurePolicy string `json:"failurePolicy,omitempty"`
 }
 
 // RunnerStatus defines the observed state of Runner
-type RunnerStatus struct{}
+type RunnerStatus struct {
+	// Effective version of GitHub Actions runner
+	// +optional
+	RunnerVersion string `json:"runnerVersion,omitempty"`
+	// Base image whose digest was resolved at the last refresh
+	// +optional
+	BaseImage string `json:"baseImage,omitempty"`
+	// Digest of the base image resolved at the last refresh
+	// +optional
+	BaseImageDigest string `json:"baseImageDigest,omitempty"`
+	// Last time the digest of the base image was resolved
+	// +optional
+	BaseImageRefreshedAt *metaV1.Time `json:"baseImageRefreshedAt,omitempty"`
+	// Runner image whose digests are recorded
+	// +optional
+	Image string `json:"image,omitempty"`
+	// Digest of the runner image
+	// +optional
+	ImageDigest string `json:"imageDigest,omitempty"`
+	// Digest of the cosign signature of the runner image
+	// +optional
+	SignatureDigest string `json:"signatureDigest,omitempty"`
+	// Digest of the cosign SBOM attestation of the runner image
+	// +optional
+	AttestationDigest string `json:"attestationDigest,omitempty"`
+	// Builds of the runner image timed out in a row, which back off the next retry
+	// +optional
+	BuildTimeouts *BuildTimeoutStatus `json:"buildTimeouts,omitempty"`
+	// State of the canary rollout of the latest change
+	// +optional
+	Rollout *RolloutStatus `json:"rollout,omitempty"`
+	// Schedules whose windows contain the current time, in the order of precedence
+	// +optional
+	ActiveSchedules []ActiveSchedule `json:"activeSchedules,omitempty"`
+	// Pools scaled by queued jobs
+	// +listType=map
+	// +listMapKey=name
+	// +optional
+	Pools []PoolStatus `json:"pools,omitempty"`
+	// Conditions of the runner, such as ImageBuilt
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metaV1.Condition `json:"conditions,omitempty"`
+}
+
+// BuildTimeoutStatus defines builds of the runner image timed out in a row
+type BuildTimeoutStatus struct {
+	// Runner image whose builds timed out
+	Image string `json:"image"`
+	// Number of builds timed out in a row
+	Count int32 `json:"count"`
+	// Last time a build timed out and its pod was deleted
+	LastTimeoutTime metaV1.Time `json:"lastTimeoutTime"`
+}
+
+// ActiveSchedule defines an active window of a schedule
+type ActiveSchedule struct {
+	// Name of the schedule
+	Name string `json:"name"`
+	// Time the window ends
+	Until metaV1.Time `json:"until"`
+}
+
+// PoolStatus defines the observed state of a pool scaled by queued jobs
+type PoolStatus struct {
+	// Name of the pool
+	Name string `json:"name"`
+	// Number of runners desired by queued and running jobs
+	Replicas int32 `json:"replicas"`
+	// Time since the pool has had no queued or running jobs
+	// +optional
+	IdleSince *metaV1.Time `json:"idleSince,omitempty"`
+}
+
+// RolloutStatus defines the observed state of the canary rollout
+type RolloutStatus struct {
+	// Phase of the rollout, one of Canary, Promoted and RolledBack
+	Phase string `json:"phase"`
+	// Hash of the pod template under rollout
+	TemplateHash string `json:"templateHash"`
+	// Time the canary runners were brought up
+	// +optional
+	StartedAt *metaV1.Time `json:"startedAt,omitempty"`
+	// Number of jobs the canary runners succeeded in
+	// +optional
+	SucceededJobs int32 `json:"succeededJobs,omitempty"`
+	// Number of jobs the canary runners failed in
+	// +optional
+	FailedJobs int32 `json:"failedJobs,omitempty"`
+	// Time up to which jobs finished by the canary runners are counted
+	// +optional
+	CountedUntil *metaV1.Time `json:"countedUntil,omitempty"`
+	// IDs of workflow runs with jobs left uncounted at the last count, which are looked at again at the next count
+	// +optional
+	PendingRunIDs []int64 `json:"pendingRunIDs,omitempty"`
+}
 
 // +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:validation:XValidation:rule="has(self.spec) && has(self.spec.repository)",message="spec.repository is required"
 
 // Runner is the schema for the runners API
 type Runner struct {
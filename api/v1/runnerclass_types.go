@@ -0,0 +1,58 @@
+package v1
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RunnerClassSpec defines defaults of runners referring to the class
+// Values of runners take precedence over those of the class. Maps and lists keyed by name, such as env and volumes, are merged.
+type RunnerClassSpec struct {
+	// Image using by self-hosted runner
+	// +optional
+	Image string `json:"image,omitempty"`
+	// Selects a key of a GitHub Token secret in the runner's namespace
+	// +optional
+	TokenSecretKeyRef *v1.SecretKeySelector `json:"tokenSecretKeyRef,omitempty"`
+	// Secret of the GitHub App in the runner's namespace
+	// +optional
+	AppSecretRef *v1.SecretEnvSource `json:"appSecretRef,omitempty"`
+	// Whether credentials of the class are only used by runners without their own credentials, or always
+	// With Enforce, tokenSecretKeyRef and appSecretRef of runners are ignored.
+	// +kubebuilder:validation:Enum=Fallback;Enforce
+	// +kubebuilder:default=Fallback
+	// +optional
+	CredentialsPolicy string `json:"credentialsPolicy,omitempty"`
+	// +optional
+	Template Template `json:"template,omitempty"`
+	// +optional
+	Build Build `json:"build,omitempty"`
+	// +optional
+	BuilderContainerSpec BuilderContainerSpec `json:"builderContainerSpec,omitempty"`
+	// +optional
+	RunnerContainerSpec RunnerContainerSpec `json:"runnerContainerSpec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+
+// RunnerClass is the schema for the runnerclasses API
+type RunnerClass struct {
+	metaV1.TypeMeta   `json:",inline"`
+	metaV1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec RunnerClassSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// RunnerClassList contains a list of RunnerClass
+type RunnerClassList struct {
+	metaV1.TypeMeta `json:",inline"`
+	metaV1.ListMeta `json:"metadata,omitempty"`
+	Items           []RunnerClass `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&RunnerClass{}, &RunnerClassList{})
+}
@@ -0,0 +1,89 @@
+package v1
+
+import (
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RunnerSetSpec defines the desired state of RunnerSet
+// +kubebuilder:validation:XValidation:rule="has(self.repositories) || has(self.selector)",message="either repositories or selector is required"
+type RunnerSetSpec struct {
+	// GitHub Repository Names to create runners for
+	// +optional
+	Repositories []RepositoryName `json:"repositories,omitempty"`
+	// Selects repositories accessible by the credentials of the template from GitHub, in addition to repositories
+	// +optional
+	Selector *RepositorySelector `json:"selector,omitempty"`
+	// Interval to list repositories selected by selector from GitHub
+	// +kubebuilder:default="10m"
+	// +optional
+	RefreshInterval *metaV1.Duration `json:"refreshInterval,omitempty"`
+	// Template of runners created per repository, whose repository is filled by the RunnerSet
+	Template RunnerTemplate `json:"template"`
+}
+
+// RepositoryName is a full name of a GitHub repository (owner/name)
+// +kubebuilder:validation:Pattern=`^[^/]+/[^/]+$`
+type RepositoryName string
+
+// RepositorySelector selects repositories from GitHub
+// Repositories are listed with tokenSecretKeyRef of the template if set, and otherwise with the GitHub App of the controller,
+// so that an empty selector selects all repositories of the installation. Archived repositories are never selected.
+type RepositorySelector struct {
+	// User or organization owning the repository, compared case-insensitively
+	// Set it with tokenSecretKeyRef, which otherwise selects repositories of all organizations the user can access.
+	// +optional
+	Owner string `json:"owner,omitempty"`
+	// Topic the repository must have
+	// +optional
+	Topic string `json:"topic,omitempty"`
+	// Regular expression the full name of the repository (owner/name) must match
+	// +optional
+	NameRegex string `json:"nameRegex,omitempty"`
+}
+
+// RunnerTemplate describes runners created by the RunnerSet
+type RunnerTemplate struct {
+	// Standard object's metadata, of which labels and annotations are copied to runners.
+	// +kubebuilder:pruning:PreserveUnknownFields
+	metaV1.ObjectMeta `json:"metadata,omitempty"`
+	// Spec of runners
+	Spec RunnerSpec `json:"spec"`
+}
+
+// RunnerSetStatus defines the observed state of RunnerSet
+type RunnerSetStatus struct {
+	// Repositories selected by selector at the last refresh
+	// +optional
+	SelectedRepositories []string `json:"selectedRepositories,omitempty"`
+	// Time repositories were last listed from GitHub
+	// +optional
+	RefreshedAt *metaV1.Time `json:"refreshedAt,omitempty"`
+	// Generation of the RunnerSet whose selector selected the repositories
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+
+// RunnerSet is the schema for the runnersets API
+type RunnerSet struct {
+	metaV1.TypeMeta   `json:",inline"`
+	metaV1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   RunnerSetSpec   `json:"spec,omitempty"`
+	Status RunnerSetStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// RunnerSetList contains a list of RunnerSet
+type RunnerSetList struct {
+	metaV1.TypeMeta `json:",inline"`
+	metaV1.ListMeta `json:"metadata,omitempty"`
+	Items           []RunnerSet `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&RunnerSet{}, &RunnerSetList{})
+}
@@ -2,8 +2,12 @@ package main
 
 import (
 	"archive/tar"
+	"bytes"
 	"compress/gzip"
+	"context"
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
 	"encoding/pem"
 	"flag"
@@ -14,17 +18,111 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
-	"path"
+	"path/filepath"
 	"regexp"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	expect "github.com/google/goexpect"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"golang.org/x/xerrors"
 )
 
+const (
+	defaultRunnerDownloadURL = "https://github.com/actions/runner/releases/download"
+	drainingFile             = ".draining"
+)
+
+var (
+	configured atomic.Bool
+	running    atomic.Bool
+	drained    atomic.Bool
+)
+
+var (
+	jobStartedPattern          = regexp.MustCompile(`Running job: (.+)$`)
+	jobCompletedPattern        = regexp.MustCompile(`Job (.+) completed with result: (\w+)`)
+	registrationFailurePattern = regexp.MustCompile(`Http response code: \w+ from 'POST .*runner-registration'|Failed to create a session|runner registration has been deleted`)
+
+	jobsStarted = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "github_actions_runner_jobs_started_total",
+		Help: "Number of jobs the runner started",
+	})
+	jobsCompleted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "github_actions_runner_jobs_completed_total",
+		Help: "Number of jobs the runner completed by result",
+	}, []string{"result"})
+	jobDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "github_actions_runner_job_duration_seconds",
+		Help:    "Duration of jobs the runner completed by result",
+		Buckets: []float64{10, 30, 60, 120, 300, 600, 1200, 1800, 3600, 7200, 21600},
+	}, []string{"result"})
+	busy = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "github_actions_runner_busy",
+		Help: "Whether the runner is executing a job",
+	})
+	registrationFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "github_actions_runner_registration_failures_total",
+		Help: "Number of failures to register the runner or to create its session",
+	})
+)
+
+// jobEventWriter passes through output of GitHub Actions runner, and records metrics from job events in it.
+type jobEventWriter struct {
+	out       io.Writer
+	buffer    []byte
+	startedAt time.Time
+}
+
+func (w *jobEventWriter) Write(p []byte) (int, error) {
+	w.buffer = append(w.buffer, p...)
+	for {
+		i := bytes.IndexByte(w.buffer, '\n')
+		if i < 0 {
+			break
+		}
+		w.observe(string(w.buffer[:i]))
+		w.buffer = w.buffer[i+1:]
+	}
+	return w.out.Write(p)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func (w *jobEventWriter) observe(line string) {
+	if jobStartedPattern.MatchString(line) {
+		jobsStarted.Inc()
+		busy.Set(1)
+		w.startedAt = time.Now()
+		return
+	}
+	if matches := jobCompletedPattern.FindStringSubmatch(line); matches != nil {
+		result := strings.ToLower(matches[2])
+		jobsCompleted.WithLabelValues(result).Inc()
+		if !w.startedAt.IsZero() {
+			jobDuration.WithLabelValues(result).Observe(time.Since(w.startedAt).Seconds())
+			w.startedAt = time.Time{}
+		}
+		busy.Set(0)
+		return
+	}
+	if registrationFailurePattern.MatchString(line) {
+		registrationFailures.Inc()
+	}
+}
+
 type TokenResponse struct {
 	Token     string `json:"token"`
 	ExpiresAt string `json:"expires_at"`
@@ -36,8 +134,52 @@ func check() {
 	}
 }
 
-func install(runnerVersion string) {
-	request, err := http.NewRequest("GET", fmt.Sprintf("https://github.com/actions/runner/releases/download/v%s/actions-runner-linux-x64-%s.tar.gz", runnerVersion, runnerVersion), nil)
+func runnerArchitecture() string {
+	switch runtime.GOARCH {
+	case "amd64":
+		return "x64"
+	case "arm64":
+		return "arm64"
+	default:
+		log.Fatalf("unsupported architecture: %s", runtime.GOARCH)
+	}
+	return ""
+}
+
+func getPublishedChecksum(runnerVersion string) string {
+	request, err := http.NewRequest("GET", fmt.Sprintf("https://api.github.com/repos/actions/runner/releases/tags/v%s", runnerVersion), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	request.Header.Set("Accept", "application/vnd.github+json")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		log.Fatalf("failed to get release of runner %s: %d", runnerVersion, response.StatusCode)
+	}
+
+	release := struct {
+		Body string `json:"body"`
+	}{}
+	if err := json.NewDecoder(response.Body).Decode(&release); err != nil {
+		log.Fatal(err)
+	}
+
+	platform := fmt.Sprintf("linux-%s", runnerArchitecture())
+	matches := regexp.MustCompile(fmt.Sprintf("<!-- BEGIN SHA %s -->([0-9a-f]{64})<!-- END SHA %s -->", platform, platform)).FindStringSubmatch(release.Body)
+	if matches == nil {
+		log.Fatalf("failed to find published checksum of %s in release of runner %s", platform, runnerVersion)
+	}
+
+	return matches[1]
+}
+
+func getMirroredChecksum(archiveURL string) string {
+	request, err := http.NewRequest("GET", archiveURL+".sha256", nil)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -45,14 +187,127 @@ func install(runnerVersion string) {
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		log.Fatalf("failed to get checksum of %s: %d", archiveURL, response.StatusCode)
+	}
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fields := strings.Fields(string(body))
+	if len(fields) == 0 {
+		log.Fatalf("failed to find checksum of %s", archiveURL)
+	}
+
+	return fields[0]
+}
+
+// getLocalChecksum returns the checksum in <archive>.sha256 next to a local archive, or empty if there is none,
+// so that installing from a local archive does not need the GitHub API.
+func getLocalChecksum(archive string) string {
+	body, err := os.ReadFile(archive + ".sha256")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ""
+		}
+		log.Fatal(err)
+	}
+
+	fields := strings.Fields(string(body))
+	if len(fields) == 0 {
+		log.Fatalf("failed to find checksum of %s", archive)
+	}
+
+	return fields[0]
+}
 
+func download(archiveURL string) string {
+	request, err := http.NewRequest("GET", archiveURL, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		log.Fatal(err)
+	}
 	defer response.Body.Close()
-	gzipReader, err := gzip.NewReader(response.Body)
+
+	if response.StatusCode != http.StatusOK {
+		log.Fatalf("failed to download %s: %d", archiveURL, response.StatusCode)
+	}
+
+	f, err := os.CreateTemp("", "actions-runner-*.tar.gz")
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, response.Body); err != nil {
+		log.Fatal(err)
+	}
+
+	return f.Name()
+}
+
+func verify(archive string, checksum string) {
+	f, err := os.Open(archive)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		log.Fatal(err)
+	}
+
+	actual := hex.EncodeToString(hash.Sum(nil))
+	if !strings.EqualFold(actual, checksum) {
+		log.Fatalf("checksum mismatch for %s: expected %s, got %s", archive, checksum, actual)
+	}
+}
+
+func within(dir string, target string) bool {
+	rel, err := filepath.Rel(dir, target)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func extract(archive string, dir string) error {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return xerrors.Errorf("failed to resolve %s: %w", dir, err)
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return xerrors.Errorf("failed to create %s: %w", root, err)
+	}
+	root, err = filepath.EvalSymlinks(root)
+	if err != nil {
+		return xerrors.Errorf("failed to resolve %s: %w", dir, err)
+	}
+
+	f, err := os.Open(archive)
+	if err != nil {
+		return xerrors.Errorf("failed to open %s: %w", archive, err)
+	}
+	defer f.Close()
 
+	gzipReader, err := gzip.NewReader(f)
+	if err != nil {
+		return xerrors.Errorf("failed to read %s: %w", archive, err)
+	}
 	defer gzipReader.Close()
+
+	// Directory modes and mtimes are restored after extraction, since read-only directories cannot be extracted into
+	// and creating entries inside them updates their mtimes.
+	directories := map[string]*tar.Header{}
+
 	tarReader := tar.NewReader(gzipReader)
 	for {
 		hdr, err := tarReader.Next()
@@ -60,30 +315,130 @@ func install(runnerVersion string) {
 			break
 		}
 		if err != nil {
-			log.Fatal(err)
+			return xerrors.Errorf("failed to read %s: %w", archive, err)
 		}
-		if hdr.Typeflag != tar.TypeDir {
-			if err := os.MkdirAll(path.Dir(hdr.Name), 0777); err != nil {
-				log.Fatal(err)
+
+		if filepath.IsAbs(hdr.Name) {
+			return xerrors.Errorf("refusing to extract absolute path: %s", hdr.Name)
+		}
+		target := filepath.Join(root, hdr.Name)
+		if !within(root, target) {
+			return xerrors.Errorf("refusing to extract path outside of %s: %s", root, hdr.Name)
+		}
+		if target == root {
+			continue
+		}
+
+		parent := filepath.Dir(target)
+		if err := os.MkdirAll(parent, 0755); err != nil {
+			return xerrors.Errorf("failed to create %s: %w", parent, err)
+		}
+		resolvedParent, err := filepath.EvalSymlinks(parent)
+		if err != nil {
+			return xerrors.Errorf("failed to resolve %s: %w", parent, err)
+		}
+		if !within(root, resolvedParent) {
+			return xerrors.Errorf("refusing to extract through symlink outside of %s: %s", root, hdr.Name)
+		}
+
+		mode := hdr.FileInfo().Mode()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return xerrors.Errorf("failed to create %s: %w", target, err)
+			}
+			directories[target] = hdr
+		case tar.TypeReg:
+			if err := os.RemoveAll(target); err != nil {
+				return xerrors.Errorf("failed to remove %s: %w", target, err)
 			}
-			f, err := os.Create(hdr.Name)
+			file, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode.Perm())
 			if err != nil {
-				log.Fatal(err)
+				return xerrors.Errorf("failed to create %s: %w", target, err)
+			}
+			if _, err := io.Copy(file, tarReader); err != nil {
+				_ = file.Close()
+				return xerrors.Errorf("failed to write %s: %w", target, err)
+			}
+			if err := file.Close(); err != nil {
+				return xerrors.Errorf("failed to close %s: %w", target, err)
+			}
+			if err := os.Chmod(target, mode.Perm()); err != nil {
+				return xerrors.Errorf("failed to chmod %s: %w", target, err)
+			}
+			if err := os.Chtimes(target, hdr.AccessTime, hdr.ModTime); err != nil {
+				return xerrors.Errorf("failed to change times of %s: %w", target, err)
+			}
+		case tar.TypeSymlink:
+			// Relative links are resolved from the real parent, which differs from parent when it contains symlinks
+			linkTarget := hdr.Linkname
+			if !filepath.IsAbs(linkTarget) {
+				linkTarget = filepath.Join(resolvedParent, linkTarget)
 			}
-			func(f *os.File) {
-				defer f.Close()
-
-				if _, err := io.Copy(f, tarReader); err != nil {
-					log.Fatal(err)
-				}
-			}(f)
-			if err := os.Chmod(hdr.Name, os.FileMode(hdr.Mode)); err != nil {
-				log.Fatal(err)
+			if !within(root, linkTarget) {
+				return xerrors.Errorf("refusing to extract symlink outside of %s: %s -> %s", root, hdr.Name, hdr.Linkname)
 			}
+			if err := os.RemoveAll(target); err != nil {
+				return xerrors.Errorf("failed to remove %s: %w", target, err)
+			}
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return xerrors.Errorf("failed to create symlink %s: %w", target, err)
+			}
+		case tar.TypeLink:
+			linkTarget := filepath.Join(root, hdr.Linkname)
+			if filepath.IsAbs(hdr.Linkname) || !within(root, linkTarget) {
+				return xerrors.Errorf("refusing to extract hardlink outside of %s: %s -> %s", root, hdr.Name, hdr.Linkname)
+			}
+			if err := os.RemoveAll(target); err != nil {
+				return xerrors.Errorf("failed to remove %s: %w", target, err)
+			}
+			if err := os.Link(linkTarget, target); err != nil {
+				return xerrors.Errorf("failed to create hardlink %s: %w", target, err)
+			}
+		default:
+			log.Printf("skip unsupported entry %s of type %c", hdr.Name, hdr.Typeflag)
+		}
+	}
+
+	for directory, hdr := range directories {
+		if err := os.Chmod(directory, hdr.FileInfo().Mode().Perm()); err != nil {
+			return xerrors.Errorf("failed to chmod %s: %w", directory, err)
+		}
+		if err := os.Chtimes(directory, hdr.ModTime, hdr.ModTime); err != nil {
+			return xerrors.Errorf("failed to change times of %s: %w", directory, err)
+		}
+	}
+
+	return nil
+}
+
+func install(runnerVersion string, downloadURL string, archive string, checksum string, dir string) {
+	if archive == "" {
+		archiveURL := fmt.Sprintf("%s/v%s/actions-runner-linux-%s-%s.tar.gz", strings.TrimSuffix(downloadURL, "/"), runnerVersion, runnerArchitecture(), runnerVersion)
+		if checksum == "" {
+			if downloadURL == defaultRunnerDownloadURL {
+				checksum = getPublishedChecksum(runnerVersion)
+			} else {
+				checksum = getMirroredChecksum(archiveURL)
+			}
+		}
+		archive = download(archiveURL)
+		defer os.Remove(archive)
+	} else if checksum == "" {
+		checksum = getLocalChecksum(archive)
+		if checksum == "" {
+			checksum = getPublishedChecksum(runnerVersion)
 		}
 	}
 
+	verify(archive, checksum)
+
+	if err := extract(archive, dir); err != nil {
+		log.Fatal(err)
+	}
+
 	command := exec.Command("bash", "bin/installdependencies.sh")
+	command.Dir = dir
 	command.Stdout = os.Stdout
 	command.Stderr = os.Stderr
 	if err := command.Run(); err != nil {
@@ -149,12 +504,21 @@ func getRemoveToken(repository string, token string) string {
 	return removeTokenResponse.Token
 }
 
-func run(registrationToken string, repository string, hostname string, disableupdate bool) {
+func run(registrationToken string, repository string, hostname string, labels string, disableupdate bool, replace bool, env []string) {
 	var args []string
 	if disableupdate {
 		args = append(args, "--disableupdate")
 	}
-	e, _, err := expect.Spawn(fmt.Sprintf("bash config.sh --labels kaidotdev/github-actions-runner-controller --token %s --url https://github.com/%s %s", registrationToken, repository, strings.Join(args, " ")), -1, expect.Verbose(true), expect.Tee(os.Stdout))
+	if replace {
+		args = append(args, "--replace")
+	}
+	runnerLabels := "kaidotdev/github-actions-runner-controller"
+	if labels != "" {
+		runnerLabels += "," + labels
+	}
+	// Arguments are passed as they are rather than split from a string, so that labels can never inject more of them
+	args = append([]string{"bash", "config.sh", "--labels", runnerLabels, "--token", registrationToken, "--url", fmt.Sprintf("https://github.com/%s", repository)}, args...)
+	e, _, err := expect.SpawnWithArgs(args, -1, expect.Verbose(true), expect.Tee(nopWriteCloser{&jobEventWriter{out: os.Stdout}}))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -186,11 +550,227 @@ func run(registrationToken string, repository string, hostname string, disableup
 	if err := e.Send("exit\n"); err != nil {
 		log.Fatal(err)
 	}
+	configured.Store(true)
 	command := exec.Command("bash", "run.sh")
+	command.Stdout = &jobEventWriter{out: os.Stdout}
+	command.Env = append(os.Environ(), env...)
+	command.Stderr = os.Stderr
+	command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := command.Start(); err != nil {
+		log.Fatal(err)
+	}
+	running.Store(true)
+	go stopWhenDrained(command)
+	err = command.Wait()
+	running.Store(false)
+	if drained.Load() {
+		return
+	}
+	// Nothing takes jobs anymore, so the pod is restarted rather than left looking healthy
+	log.Fatalf("run.sh exited: %+v", err)
+}
+
+// serveHealth serves /healthz reporting whether run.sh is alive, and /readyz reporting whether the runner is configured and taking jobs.
+func serveHealth(address string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		if configured.Load() && !running.Load() && !drained.Load() {
+			http.Error(w, "run.sh is not running", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, _ *http.Request) {
+		if !configured.Load() {
+			http.Error(w, "runner is not configured", http.StatusServiceUnavailable)
+			return
+		}
+		if !running.Load() {
+			http.Error(w, "run.sh is not running", http.StatusServiceUnavailable)
+			return
+		}
+		if _, err := os.Stat(drainingFile); err == nil {
+			http.Error(w, "runner is draining", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	})
+	log.Fatal(http.ListenAndServe(address, mux))
+}
+
+func serveMetrics(address string) {
+	prometheus.MustRegister(jobsStarted, jobsCompleted, jobDuration, busy, registrationFailures)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	log.Fatal(http.ListenAndServe(address, mux))
+}
+
+// writeHookWrapper writes a script running the hook through this binary to apply its timeout and failure policy,
+// since GitHub Actions runner only runs hooks given as script files, and returns the path of the script.
+func writeHookWrapper(name string, hook string, timeout time.Duration, failurePolicy string) string {
+	executable, err := os.Executable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	dir, err := filepath.Abs(".hooks")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Fatal(err)
+	}
+
+	path := filepath.Join(dir, name+".sh")
+	script := fmt.Sprintf("#!/bin/bash\nexec '%s' --run-hook='%s' --hook-timeout=%s --hook-failure-policy=%s\n", executable, hook, timeout, failurePolicy)
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		log.Fatal(err)
+	}
+	return path
+}
+
+// runHook runs the hook within the timeout, and returns the exit code failing the job only if the failure policy is Fail.
+func runHook(hook string, timeout time.Duration, failurePolicy string) int {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	command := exec.CommandContext(ctx, "bash", hook)
 	command.Stdout = os.Stdout
 	command.Stderr = os.Stderr
-	if err := command.Run(); err != nil {
+	// Processes started by the hook are killed together with it on timeout, as they would otherwise outlive the job
+	command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	command.Cancel = func() error {
+		return syscall.Kill(-command.Process.Pid, syscall.SIGKILL)
+	}
+	err := command.Run()
+	if err == nil {
+		return 0
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		log.Printf("Hook %s timed out after %s", hook, timeout)
+	} else {
+		log.Printf("Hook %s failed: %+v", hook, err)
+	}
+	if failurePolicy == "Ignore" {
+		return 0
+	}
+	return 1
+}
+
+// lastUsed returns the latest modification time of the path and anything under it, or access time of files under it.
+// Access times of directories are not used, as walking them to prune updates them.
+// Access times are updated at most daily with relatime, which is enough to keep entries used within maxAge.
+func lastUsed(path string) time.Time {
+	var latest time.Time
+	_ = filepath.WalkDir(path, func(_ string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok && info.Mode().IsRegular() {
+			if accessed := time.Unix(stat.Atim.Unix()); accessed.After(latest) {
+				latest = accessed
+			}
+		}
+		return nil
+	})
+	return latest
+}
+
+// prune removes entries at the depth under dir, such as <tool>/<version> of the tool cache, which were not used for maxAge.
+func prune(dir string, depth int, maxAge time.Duration) {
+	pattern := dir
+	for i := 0; i < depth; i++ {
+		pattern = filepath.Join(pattern, "*")
+	}
+	entries, err := filepath.Glob(pattern)
+	if err != nil {
 		log.Printf("%+v", err)
+		return
+	}
+	for _, entry := range entries {
+		if time.Since(lastUsed(entry)) < maxAge {
+			continue
+		}
+		log.Printf("Prune: %s", entry)
+		// Tools are looked up by their <arch>.complete markers, so they are no longer found once the markers are removed
+		markers, _ := filepath.Glob(filepath.Join(entry, "*.complete"))
+		for _, marker := range markers {
+			if err := os.Remove(marker); err != nil && !os.IsNotExist(err) {
+				log.Printf("%+v", err)
+			}
+		}
+		if err := os.RemoveAll(entry); err != nil {
+			log.Printf("%+v", err)
+		}
+	}
+}
+
+// startPruning prunes dir hourly while no job is running.
+func startPruning(dir string, depth int, maxAge time.Duration) {
+	for {
+		if !jobInProgress() {
+			prune(dir, depth, maxAge)
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+// processRunning returns whether a process whose command line contains name is running.
+func processRunning(name string) bool {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		log.Printf("%+v", err)
+		return false
+	}
+	for _, entry := range entries {
+		if _, err := strconv.Atoi(entry.Name()); err != nil {
+			continue
+		}
+		cmdline, err := os.ReadFile(filepath.Join("/proc", entry.Name(), "cmdline"))
+		if err != nil {
+			continue
+		}
+		if strings.Contains(string(cmdline), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// jobInProgress returns whether GitHub Actions runner is executing a job, for which it spawns Runner.Worker.
+func jobInProgress() bool {
+	return processRunning("Runner.Worker")
+}
+
+// stopWhenDrained stops run.sh so that no more jobs are taken once the runner is marked as draining and the current job finished.
+func stopWhenDrained(command *exec.Cmd) {
+	for {
+		if _, err := os.Stat(drainingFile); err == nil && !jobInProgress() {
+			log.Printf("Drained")
+			drained.Store(true)
+			if err := syscall.Kill(-command.Process.Pid, syscall.SIGINT); err != nil {
+				log.Printf("%+v", err)
+			}
+			return
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// drain marks the runner as draining, and waits until the current job finishes and GitHub Actions runner stops.
+func drain() {
+	if err := os.WriteFile(drainingFile, nil, 0644); err != nil {
+		log.Fatal(err)
+	}
+	for jobInProgress() || processRunning("Runner.Listener") {
+		time.Sleep(time.Second)
 	}
 }
 
@@ -205,8 +785,13 @@ func remove(registrationToken string) {
 
 func main() {
 	var runnerVersion string
+	var runnerDir string
+	var runnerDownloadURL string
+	var runnerArchive string
+	var runnerChecksum string
 	var repository string
 	var hostname string
+	var labels string
 	var token string
 	var githubAppId string
 	var githubAppInstallationId string
@@ -214,26 +799,104 @@ func main() {
 	var onlyInstall bool
 	var withoutInstall bool
 	var disableupdate bool
-	flag.StringVar(&runnerVersion, "runner-version", "2.291.1", "Version of GitHub Actions runner")
+	var replace bool
+	var drainOnly bool
+	var checkJobInProgress bool
+	var healthAddress string
+	var metricsAddress string
+	var jobStartedHook string
+	var jobStartedHookTimeout time.Duration
+	var jobStartedHookFailurePolicy string
+	var jobCompletedHook string
+	var jobCompletedHookTimeout time.Duration
+	var jobCompletedHookFailurePolicy string
+	var pruneToolCache string
+	var pruneToolCacheAfter time.Duration
+	var pruneWorkAfter time.Duration
+	var hook string
+	var hookTimeout time.Duration
+	var hookFailurePolicy string
+	flag.StringVar(&runnerVersion, "runner-version", "2.321.0", "Version of GitHub Actions runner")
+	flag.StringVar(&runnerDir, "runner-dir", ".", "Directory to install GitHub Actions runner into and run it from")
+	flag.StringVar(&runnerDownloadURL, "runner-download-url", defaultRunnerDownloadURL, "Base URL to download GitHub Actions runner from, such as a mirror for air-gapped clusters")
+	flag.StringVar(&runnerArchive, "runner-archive", "", "Path to a local GitHub Actions runner archive to install instead of downloading")
+	flag.StringVar(&runnerChecksum, "runner-sha256", "", "Expected SHA-256 checksum of GitHub Actions runner archive (resolved from <archive>.sha256 of a local archive, a mirror, or published hashes if empty)")
 	flag.StringVar(&repository, "repository", "kaidotdev/github-actions-runner-controller", "GitHub Repository Name")
 	flag.StringVar(&token, "token", "********", "GitHub Token")
 	flag.StringVar(&hostname, "hostname", "runner", "Hostname used as Runner name")
+	flag.StringVar(&labels, "labels", "", "Comma-separated labels of the runner in addition to kaidotdev/github-actions-runner-controller")
 	flag.StringVar(&githubAppId, "github-app-id", "", "GitHub App ID")
 	flag.StringVar(&githubAppInstallationId, "github-app-installation-id", "", "GitHub App Installation ID")
 	flag.StringVar(&githubAppPrivateKey, "github-app-private-key", "", "GitHub App Private Key")
 	flag.BoolVar(&onlyInstall, "only-install", false, "Execute install only")
 	flag.BoolVar(&withoutInstall, "without-install", false, "Execute without install")
 	flag.BoolVar(&disableupdate, "disableupdate", false, "Disable self-hosted runner automatic update to the latest released version")
+	flag.BoolVar(&replace, "replace", false, "Replace the runner registered with the same name, for runners with stable names")
+	flag.BoolVar(&drainOnly, "drain", false, "Mark the running runner as draining and wait until its current job finishes")
+	flag.StringVar(&healthAddress, "health-address", "0.0.0.0:8081", "Address serving /healthz and /readyz of the runner")
+	flag.StringVar(&metricsAddress, "metrics-address", "", "Address serving Prometheus metrics of jobs executed by the runner (disabled if empty)")
+	flag.StringVar(&jobStartedHook, "job-started-hook", "", "Path to a bash script run before each job")
+	flag.DurationVar(&jobStartedHookTimeout, "job-started-hook-timeout", 5*time.Minute, "Duration the job-started hook may run before it is killed")
+	flag.StringVar(&jobStartedHookFailurePolicy, "job-started-hook-failure-policy", "Fail", "Whether failure of the job-started hook fails the job (Fail) or is only logged (Ignore)")
+	flag.StringVar(&jobCompletedHook, "job-completed-hook", "", "Path to a bash script run after each job")
+	flag.DurationVar(&jobCompletedHookTimeout, "job-completed-hook-timeout", 5*time.Minute, "Duration the job-completed hook may run before it is killed")
+	flag.StringVar(&jobCompletedHookFailurePolicy, "job-completed-hook-failure-policy", "Fail", "Whether failure of the job-completed hook fails the job (Fail) or is only logged (Ignore)")
+	flag.StringVar(&pruneToolCache, "prune-tool-cache", "", "Prune versions of tools in the tool cache directory not used for --prune-tool-cache-after once and exit, run by the pruning CronJob of the shared tool cache")
+	flag.DurationVar(&pruneToolCacheAfter, "prune-tool-cache-after", 0, "Duration versions of tools given by --prune-tool-cache are kept after they were last used")
+	flag.DurationVar(&pruneWorkAfter, "prune-work-after", 0, "Prune entries of the work folder not used for this long (disabled if 0)")
+	flag.StringVar(&hook, "run-hook", "", "Run the hook with --hook-timeout and --hook-failure-policy, used by GitHub Actions runner")
+	flag.DurationVar(&hookTimeout, "hook-timeout", 5*time.Minute, "Duration the hook given by --run-hook may run before it is killed")
+	flag.StringVar(&hookFailurePolicy, "hook-failure-policy", "Fail", "Whether failure of the hook given by --run-hook fails the job (Fail) or is only logged (Ignore)")
+	flag.BoolVar(&checkJobInProgress, "job-in-progress", false, "Exit with 0 if the running runner is executing a job, and 1 otherwise")
 	flag.Parse()
 
+	if hook != "" {
+		os.Exit(runHook(hook, hookTimeout, hookFailurePolicy))
+	}
+
+	if pruneToolCache != "" {
+		if pruneToolCacheAfter <= 0 {
+			log.Fatal("--prune-tool-cache-after must be positive")
+		}
+		prune(pruneToolCache, 2, pruneToolCacheAfter)
+		os.Exit(0)
+	}
+
+	if drainOnly || checkJobInProgress {
+		if err := os.Chdir(runnerDir); err != nil {
+			log.Fatal(err)
+		}
+		if checkJobInProgress {
+			if !jobInProgress() {
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
+		log.Printf("Drain")
+		drain()
+		os.Exit(0)
+	}
+
 	check()
 	if !withoutInstall {
-		install(runnerVersion)
+		install(runnerVersion, runnerDownloadURL, runnerArchive, runnerChecksum, runnerDir)
 		if onlyInstall {
 			os.Exit(0)
 		}
 	}
 
+	if err := os.Chdir(runnerDir); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.Remove(drainingFile); err != nil && !os.IsNotExist(err) {
+		log.Fatal(err)
+	}
+
+	go serveHealth(healthAddress)
+	if metricsAddress != "" {
+		go serveMetrics(metricsAddress)
+	}
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGTERM, syscall.SIGKILL)
 
@@ -273,7 +936,17 @@ func main() {
 
 	log.Printf("Run: %s", hostname)
 	registrationToken := getRegistrationToken(repository, token)
-	go run(registrationToken, repository, hostname, disableupdate)
+	var env []string
+	if jobStartedHook != "" {
+		env = append(env, "ACTIONS_RUNNER_HOOK_JOB_STARTED="+writeHookWrapper("job-started", jobStartedHook, jobStartedHookTimeout, jobStartedHookFailurePolicy))
+	}
+	if jobCompletedHook != "" {
+		env = append(env, "ACTIONS_RUNNER_HOOK_JOB_COMPLETED="+writeHookWrapper("job-completed", jobCompletedHook, jobCompletedHookTimeout, jobCompletedHookFailurePolicy))
+	}
+	if pruneWorkAfter > 0 {
+		go startPruning("_work", 1, pruneWorkAfter)
+	}
+	go run(registrationToken, repository, hostname, labels, disableupdate, replace, env)
 
 	<-quit
 	log.Printf("Remove: %s", hostname)
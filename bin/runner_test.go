@@ -0,0 +1,430 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeArchive(t *testing.T, headers []*tar.Header) string {
+	t.Helper()
+
+	var buffer bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buffer)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for _, hdr := range headers {
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len(hdr.Name))
+		}
+		if err := tarWriter.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if _, err := tarWriter.Write([]byte(hdr.Name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := filepath.Join(t.TempDir(), "actions-runner.tar.gz")
+	if err := os.WriteFile(archive, buffer.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return archive
+}
+
+func TestExtract(t *testing.T) {
+	type in struct {
+		headers []*tar.Header
+	}
+
+	type want struct {
+		err   bool
+		files []string
+	}
+
+	cases := []struct {
+		name string
+		in   in
+		want want
+	}{
+		{
+			"extracts files, directories and symlinks inside dir",
+			in{
+				[]*tar.Header{
+					{Name: "bin/", Typeflag: tar.TypeDir, Mode: 0755},
+					{Name: "bin/Runner.Listener", Typeflag: tar.TypeReg, Mode: 0755},
+					{Name: "run.sh", Typeflag: tar.TypeReg, Mode: 0755},
+					{Name: "bin/run.sh", Typeflag: tar.TypeSymlink, Linkname: "../run.sh"},
+					{Name: "bin/link", Typeflag: tar.TypeLink, Linkname: "run.sh"},
+				},
+			},
+			want{
+				false,
+				[]string{"bin/Runner.Listener", "bin/run.sh", "bin/link"},
+			},
+		},
+		{
+			"extracts children of read-only directories",
+			in{
+				[]*tar.Header{
+					{Name: "externals/", Typeflag: tar.TypeDir, Mode: 0555},
+					{Name: "externals/node", Typeflag: tar.TypeReg, Mode: 0755},
+				},
+			},
+			want{
+				false,
+				[]string{"externals/node"},
+			},
+		},
+		{
+			"refuses parent paths",
+			in{
+				[]*tar.Header{
+					{Name: "../evil", Typeflag: tar.TypeReg, Mode: 0644},
+				},
+			},
+			want{
+				true,
+				nil,
+			},
+		},
+		{
+			"refuses parent paths inside directories",
+			in{
+				[]*tar.Header{
+					{Name: "bin/../../evil", Typeflag: tar.TypeReg, Mode: 0644},
+				},
+			},
+			want{
+				true,
+				nil,
+			},
+		},
+		{
+			"refuses absolute paths",
+			in{
+				[]*tar.Header{
+					{Name: "/tmp/evil", Typeflag: tar.TypeReg, Mode: 0644},
+				},
+			},
+			want{
+				true,
+				nil,
+			},
+		},
+		{
+			"refuses symlinks outside dir",
+			in{
+				[]*tar.Header{
+					{Name: "escape", Typeflag: tar.TypeSymlink, Linkname: "../"},
+				},
+			},
+			want{
+				true,
+				nil,
+			},
+		},
+		{
+			"refuses absolute symlinks outside dir",
+			in{
+				[]*tar.Header{
+					{Name: "escape", Typeflag: tar.TypeSymlink, Linkname: "/etc"},
+				},
+			},
+			want{
+				true,
+				nil,
+			},
+		},
+		{
+			"refuses symlink chains leading outside dir",
+			in{
+				[]*tar.Header{
+					{Name: "self", Typeflag: tar.TypeSymlink, Linkname: "."},
+					{Name: "self/escape", Typeflag: tar.TypeSymlink, Linkname: ".."},
+				},
+			},
+			want{
+				true,
+				nil,
+			},
+		},
+		{
+			"extracts files through symlinks inside dir",
+			in{
+				[]*tar.Header{
+					{Name: "sub/", Typeflag: tar.TypeDir, Mode: 0755},
+					{Name: "sub/up", Typeflag: tar.TypeSymlink, Linkname: ".."},
+					{Name: "sub/up/up/file", Typeflag: tar.TypeReg, Mode: 0644},
+				},
+			},
+			want{
+				false,
+				[]string{"up/file"},
+			},
+		},
+		{
+			"refuses symlinks through symlinks leading outside dir",
+			in{
+				[]*tar.Header{
+					{Name: "sub/", Typeflag: tar.TypeDir, Mode: 0755},
+					{Name: "sub/up", Typeflag: tar.TypeSymlink, Linkname: ".."},
+					{Name: "sub/up/escape", Typeflag: tar.TypeSymlink, Linkname: "../sub"},
+				},
+			},
+			want{
+				true,
+				nil,
+			},
+		},
+		{
+			"refuses hardlinks outside dir",
+			in{
+				[]*tar.Header{
+					{Name: "passwd", Typeflag: tar.TypeLink, Linkname: "../../etc/passwd"},
+				},
+			},
+			want{
+				true,
+				nil,
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			parent := t.TempDir()
+			dir := filepath.Join(parent, "runner")
+			t.Cleanup(func() {
+				// Read-only directories cannot be removed from by non-root users
+				_ = filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+					if err == nil && d.IsDir() {
+						_ = os.Chmod(path, 0755)
+					}
+					return nil
+				})
+			})
+			err := extract(writeArchive(t, tc.in.headers), dir)
+			if tc.want.err {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				entries, err := os.ReadDir(parent)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, entry := range entries {
+					if entry.Name() != "runner" {
+						t.Errorf("extracted %s outside of dir", entry.Name())
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, file := range tc.want.files {
+				if _, err := os.Stat(filepath.Join(dir, file)); err != nil {
+					t.Errorf("expected %s: %v", file, err)
+				}
+			}
+			for _, hdr := range tc.in.headers {
+				if hdr.Typeflag != tar.TypeDir {
+					continue
+				}
+				info, err := os.Stat(filepath.Join(dir, hdr.Name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if info.Mode().Perm() != os.FileMode(hdr.Mode).Perm() {
+					t.Errorf("mode of %s: want %v, got %v", hdr.Name, os.FileMode(hdr.Mode).Perm(), info.Mode().Perm())
+				}
+			}
+		})
+	}
+}
+
+func TestRunHook(t *testing.T) {
+	type in struct {
+		failurePolicy string
+	}
+
+	type want struct {
+		code int
+	}
+
+	cases := []struct {
+		name string
+		in   in
+		want want
+	}{
+		{
+			"kills the process group of a timed out hook and fails",
+			in{
+				"Fail",
+			},
+			want{
+				1,
+			},
+		},
+		{
+			"kills the process group of a timed out hook and ignores the failure",
+			in{
+				"Ignore",
+			},
+			want{
+				0,
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			pidFile := filepath.Join(dir, "pid")
+			hook := filepath.Join(dir, "hook.sh")
+			if err := os.WriteFile(hook, []byte(fmt.Sprintf("sleep 60 &\necho $! > %s\nwait\n", pidFile)), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := runHook(hook, 500*time.Millisecond, tc.in.failurePolicy); got != tc.want.code {
+				t.Errorf("code: want %d, got %d", tc.want.code, got)
+			}
+
+			b, err := os.ReadFile(pidFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for deadline := time.Now().Add(5 * time.Second); pidRunning(pid); time.Sleep(100 * time.Millisecond) {
+				if time.Now().After(deadline) {
+					t.Fatalf("process %d started by the hook is still running", pid)
+				}
+			}
+		})
+	}
+}
+
+// pidRunning reports whether the process exists and is not a zombie waiting to be reaped.
+func pidRunning(pid int) bool {
+	b, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	fields := strings.Fields(string(b)[strings.LastIndex(string(b), ")")+1:])
+	return len(fields) > 0 && fields[0] != "Z"
+}
+
+func TestPrune(t *testing.T) {
+	old := time.Now().Add(-48 * time.Hour)
+	recent := time.Now().Add(-time.Minute)
+
+	type in struct {
+		accessed time.Time
+		modified time.Time
+	}
+
+	type want struct {
+		exists bool
+	}
+
+	cases := []struct {
+		name string
+		in   in
+		want want
+	}{
+		{
+			"removes tools neither used nor modified for maxAge",
+			in{
+				old,
+				old,
+			},
+			want{
+				false,
+			},
+		},
+		{
+			"keeps tools used recently",
+			in{
+				recent,
+				old,
+			},
+			want{
+				true,
+			},
+		},
+		{
+			"keeps tools modified recently",
+			in{
+				old,
+				recent,
+			},
+			want{
+				true,
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			version := filepath.Join(dir, "go", "1.22.0")
+			if err := os.MkdirAll(filepath.Join(version, "x64", "bin"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			paths := []string{
+				filepath.Join(version, "x64.complete"),
+				filepath.Join(version, "x64", "bin", "go"),
+			}
+			for _, path := range paths {
+				if err := os.WriteFile(path, nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, path := range paths {
+				if err := os.Chtimes(path, tc.in.accessed, tc.in.modified); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Directories are accessed recently by walking them
+			for _, path := range []string{filepath.Join(version, "x64", "bin"), filepath.Join(version, "x64"), version} {
+				if err := os.Chtimes(path, recent, tc.in.modified); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			prune(dir, 2, 24*time.Hour)
+
+			_, err := os.Stat(version)
+			if got := err == nil; got != tc.want.exists {
+				t.Errorf("exists: want %t, got %t", tc.want.exists, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	garV1 "github-actions-runner-controller/api/v1"
+
+	dockerref "github.com/docker/distribution/reference"
+	"golang.org/x/xerrors"
+)
+
+// resolveImageDigest resolves the manifest digest that the tag of image currently points to in its source registry,
+// with the credentials the docker config of the runner has for the source registry, and anonymously otherwise.
+func (r *RunnerReconciler) resolveImageDigest(ctx context.Context, runner *garV1.Runner, image string) (string, error) {
+	named, err := dockerref.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", xerrors.Errorf("failed to parse image %s: %w", image, err)
+	}
+	if canonical, ok := named.(dockerref.Canonical); ok {
+		return canonical.Digest().String(), nil
+	}
+	tagged := dockerref.TagNameOnly(named).(dockerref.Tagged)
+
+	domain := dockerref.Domain(named)
+	if domain == "docker.io" {
+		domain = "registry-1.docker.io"
+	}
+	manifestURL := fmt.Sprintf("https://%s/v2/%s/manifests/%s", domain, dockerref.Path(named), tagged.Tag())
+
+	registry := r.buildRegistry(runner)
+	// TLS options of the registry configuration are those of the push registry, and not of the source registry
+	registryClient, err := r.newRegistryClient(ctx, runner.Namespace, garV1.Registry{
+		DockerConfigSecretRef: registry.DockerConfigSecretRef,
+	})
+	if err != nil {
+		return "", err
+	}
+	// Credentials of other registries in the docker config, such as the push registry, are never sent to the source registry
+	return registryClient.scopedTo(domain).getManifestDigest(ctx, manifestURL)
+}
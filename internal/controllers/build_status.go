@@ -0,0 +1,184 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	garV1 "github-actions-runner-controller/api/v1"
+
+	coreV1 "k8s.io/api/core/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	conditionImageBuilt = "ImageBuilt"
+	buildLogTailLines   = 20
+	maxConditionMessage = 4096
+)
+
+// updateBuildCondition sets the ImageBuilt condition from the init containers building and signing the image of runner pods running the current image,
+// and returns whether the condition changed.
+func (r *RunnerReconciler) updateBuildCondition(ctx context.Context, runner *garV1.Runner) (bool, error) {
+	pods, err := r.listRunnerPods(ctx, runner)
+	if err != nil {
+		return false, err
+	}
+	r.buildObservations.record(client.ObjectKeyFromObject(runner), pods)
+
+	image := r.buildPullImage(runner)
+	var condition *metaV1.Condition
+	var failedPod *v1.Pod
+	var failedContainer string
+	var failedPrevious bool
+	for _, pod := range pods {
+		pod := pod
+
+		if !podRunsImage(&pod, image) {
+			continue
+		}
+
+		succeeded := len(pod.Status.InitContainerStatuses) > 0
+		for _, status := range pod.Status.InitContainerStatuses {
+			terminated := status.State.Terminated
+			previous := false
+			if terminated == nil {
+				succeeded = false
+				terminated = status.LastTerminationState.Terminated
+				previous = true
+			}
+			if terminated == nil || terminated.ExitCode == 0 {
+				continue
+			}
+			succeeded = false
+
+			message := fmt.Sprintf("Container %q of pod %q exited with %d", status.Name, pod.Name, terminated.ExitCode)
+			if terminated.Message != "" {
+				message += ": " + strings.TrimSpace(terminated.Message)
+			}
+			condition = &metaV1.Condition{
+				Type:    conditionImageBuilt,
+				Status:  metaV1.ConditionFalse,
+				Reason:  "BuildFailed",
+				Message: truncateMessage(message, maxConditionMessage),
+			}
+			failedPod, failedContainer, failedPrevious = &pod, status.Name, previous
+		}
+
+		if succeeded && condition == nil {
+			condition = &metaV1.Condition{
+				Type:    conditionImageBuilt,
+				Status:  metaV1.ConditionTrue,
+				Reason:  "BuildSucceeded",
+				Message: fmt.Sprintf("Built %s", image),
+			}
+		}
+	}
+
+	if condition == nil {
+		return false, nil
+	}
+	condition.ObservedGeneration = runner.Generation
+	if !meta.SetStatusCondition(&runner.Status.Conditions, *condition) {
+		return false, nil
+	}
+
+	if condition.Status == metaV1.ConditionFalse {
+		// The log tail is only put into the event, as it changes on every restart of the builder and would update the condition every time
+		message := condition.Message
+		if tail := r.getLogTail(ctx, failedPod, failedContainer, failedPrevious); tail != "" {
+			message += "\n" + tail
+		}
+		r.Recorder.Eventf(runner, coreV1.EventTypeWarning, "FailedBuild", "Failed to build image: %s", truncateMessage(message, maxConditionMessage))
+	} else {
+		r.Recorder.Eventf(runner, coreV1.EventTypeNormal, "SuccessfulBuilt", "Built image: %q", image)
+	}
+	return true, nil
+}
+
+// truncateMessage keeps the last bytes of the message up to max, cutting it on a boundary of UTF-8 characters.
+func truncateMessage(message string, max int) string {
+	if len(message) <= max {
+		return message
+	}
+	i := len(message) - max
+	for i < len(message) && !utf8.RuneStart(message[i]) {
+		i++
+	}
+	return message[i:]
+}
+
+// buildDurationOf returns the time from the start of the first init container to the end of the last one that terminated.
+func buildDurationOf(pod *v1.Pod) time.Duration {
+	var startedAt, finishedAt time.Time
+	for _, status := range pod.Status.InitContainerStatuses {
+		terminated := status.State.Terminated
+		if terminated == nil {
+			terminated = status.LastTerminationState.Terminated
+		}
+		if terminated == nil {
+			continue
+		}
+		if startedAt.IsZero() || terminated.StartedAt.Time.Before(startedAt) {
+			startedAt = terminated.StartedAt.Time
+		}
+		if terminated.FinishedAt.Time.After(finishedAt) {
+			finishedAt = terminated.FinishedAt.Time
+		}
+	}
+	if startedAt.IsZero() {
+		return 0
+	}
+	return finishedAt.Sub(startedAt)
+}
+
+// podRunsImage reports whether the pod runs the image, or the image pinned to a digest.
+func podRunsImage(pod *v1.Pod, image string) bool {
+	for _, container := range pod.Spec.Containers {
+		if container.Image == image || strings.HasPrefix(container.Image, image+"@") {
+			return true
+		}
+	}
+	return false
+}
+
+// getLogTail returns the last lines of the log of the container, or an empty string if they are not available.
+func (r *RunnerReconciler) getLogTail(ctx context.Context, pod *v1.Pod, container string, previous bool) string {
+	if r.KubernetesClient == nil {
+		return ""
+	}
+
+	logs, err := r.KubernetesClient.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &v1.PodLogOptions{
+		Container: container,
+		Previous:  previous,
+		TailLines: func(i int64) *int64 { return &i }(buildLogTailLines),
+	}).DoRaw(ctx)
+	if err != nil {
+		r.Log.V(1).Info("failed to get logs", "pod", pod.Name, "container", container, "error", err.Error())
+		return ""
+	}
+	return strings.TrimSpace(string(logs))
+}
+
+// mapPodToRunner enqueues the runner owning the stable or canary deployment of the pod.
+func mapPodToRunner(ctx context.Context, object client.Object) []reconcile.Request {
+	app := strings.TrimSuffix(object.GetLabels()["app"], "-canary")
+	if !strings.HasSuffix(app, "-runner") {
+		return nil
+	}
+	return []reconcile.Request{
+		{
+			NamespacedName: types.NamespacedName{
+				Name:      strings.TrimSuffix(app, "-runner"),
+				Namespace: object.GetNamespace(),
+			},
+		},
+	}
+}
@@ -0,0 +1,249 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	garV1 "github-actions-runner-controller/api/v1"
+
+	"golang.org/x/xerrors"
+	coreV1 "k8s.io/api/core/v1"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	builderKaniko   = "kaniko"
+	builderBuildKit = "buildkit"
+	builderNone     = "none"
+)
+
+// imageBuilder builds the runner image from the workspace config map in an init container of runner pods.
+type imageBuilder interface {
+	// name returns the name of the builder container.
+	name() string
+	// container returns the builder container pushing the runner image to destination.
+	container(runner *garV1.Runner, destination string, registry garV1.Registry) v1.Container
+	// workspace returns files put into the workspace config map next to Dockerfile.
+	workspace(runner *garV1.Runner, destination string, registry garV1.Registry) map[string]string
+	// annotations returns annotations required on runner pods.
+	annotations() map[string]string
+	// pushedDigest returns the digest of the runner image pushed by the builder container from its termination message, or an empty string if it is unknown.
+	pushedDigest(message string) string
+}
+
+// builder returns the image builder of the runner, or nil if the image is used as is.
+func (r *RunnerReconciler) builder(runner *garV1.Runner) imageBuilder {
+	builder := runner.Spec.Build.Builder
+	if builder == "" {
+		builder = r.Builder
+	}
+	switch builder {
+	case builderNone:
+		return nil
+	case builderBuildKit:
+		return &buildkitBuilder{image: r.BuildKitImage}
+	default:
+		// Builders are validated by the CRD and by validateBuilder at startup
+		return &kanikoBuilder{image: r.KanikoImage}
+	}
+}
+
+// validateBuilder validates the default builder of the controller.
+func validateBuilder(builder string) error {
+	switch builder {
+	case builderKaniko, builderBuildKit, builderNone:
+		return nil
+	default:
+		return xerrors.Errorf("unknown builder %q, which must be one of %s, %s or %s", builder, builderKaniko, builderBuildKit, builderNone)
+	}
+}
+
+type kanikoBuilder struct {
+	image string
+}
+
+func (b *kanikoBuilder) name() string {
+	return builderKaniko
+}
+
+func (b *kanikoBuilder) container(runner *garV1.Runner, destination string, registry garV1.Registry) v1.Container {
+	args := []string{
+		"--dockerfile=Dockerfile",
+		"--context=dir:///workspace",
+		"--cache=true",
+		"--compressed-caching=false",
+		fmt.Sprintf("--custom-platform=linux/%s", architecture(runner)),
+		fmt.Sprintf("--destination=%s", destination),
+		fmt.Sprintf("--digest-file=%s", coreV1.TerminationMessagePathDefault),
+	}
+	kaniko := runner.Spec.Build.Kaniko
+	if kaniko.CacheRepository != "" {
+		args = append(args, fmt.Sprintf("--cache-repo=%s", kaniko.CacheRepository))
+	}
+	if kaniko.CacheTTL != nil {
+		args = append(args, fmt.Sprintf("--cache-ttl=%s", kaniko.CacheTTL.Duration))
+	}
+	if kaniko.SnapshotMode != "" {
+		args = append(args, fmt.Sprintf("--snapshot-mode=%s", kaniko.SnapshotMode))
+	}
+	volumeMounts := []v1.VolumeMount{
+		{
+			Name:      "workspace",
+			MountPath: "/workspace/Dockerfile",
+			SubPath:   "Dockerfile",
+			ReadOnly:  true,
+		},
+	}
+
+	if registry.DockerConfigSecretRef != nil {
+		volumeMounts = append(volumeMounts, v1.VolumeMount{
+			Name:      "registry-docker-config",
+			MountPath: "/kaniko/.docker",
+			ReadOnly:  true,
+		})
+	}
+	if registryInsecure(registry) {
+		args = append(args, "--insecure", "--skip-tls-verify")
+	}
+	if registry.CACertificateConfigMapKeyRef != nil {
+		args = append(args, fmt.Sprintf("--registry-certificate=%s=/kaniko/ssl/registry/ca.crt", strings.SplitN(destination, "/", 2)[0]))
+		volumeMounts = append(volumeMounts, v1.VolumeMount{
+			Name:      "registry-ca-certificate",
+			MountPath: "/kaniko/ssl/registry",
+			ReadOnly:  true,
+		})
+	}
+
+	return v1.Container{
+		Name:            b.name(),
+		Image:           b.image,
+		ImagePullPolicy: v1.PullIfNotPresent,
+		Args:            append(args, kaniko.ExtraArgs...),
+		VolumeMounts:    volumeMounts,
+	}
+}
+
+func (b *kanikoBuilder) workspace(runner *garV1.Runner, destination string, registry garV1.Registry) map[string]string {
+	return nil
+}
+
+func (b *kanikoBuilder) annotations() map[string]string {
+	return nil
+}
+
+func (b *kanikoBuilder) pushedDigest(message string) string {
+	digest := strings.TrimSpace(message)
+	if !strings.HasPrefix(digest, "sha256:") {
+		return ""
+	}
+	return digest
+}
+
+// buildkitBuilder builds with rootless buildctl-daemonless.sh, which needs unconfined seccomp and AppArmor profiles.
+type buildkitBuilder struct {
+	image string
+}
+
+func (b *buildkitBuilder) name() string {
+	return builderBuildKit
+}
+
+func (b *buildkitBuilder) container(runner *garV1.Runner, destination string, registry garV1.Registry) v1.Container {
+	output := fmt.Sprintf("--output=type=image,name=%s,push=true", destination)
+	if registryInsecure(registry) {
+		output += ",registry.insecure=true"
+	}
+	args := []string{
+		"build",
+		"--frontend=dockerfile.v0",
+		"--local=context=/workspace",
+		"--local=dockerfile=/workspace",
+		fmt.Sprintf("--opt=platform=linux/%s", architecture(runner)),
+		output,
+		"--export-cache=type=inline",
+		fmt.Sprintf("--import-cache=type=registry,ref=%s", destination),
+		fmt.Sprintf("--metadata-file=%s", coreV1.TerminationMessagePathDefault),
+	}
+	volumeMounts := []v1.VolumeMount{
+		{
+			Name:      "workspace",
+			MountPath: "/workspace/Dockerfile",
+			SubPath:   "Dockerfile",
+			ReadOnly:  true,
+		},
+		{
+			Name:      "workspace",
+			MountPath: "/home/user/.config/buildkit/buildkitd.toml",
+			SubPath:   "buildkitd.toml",
+			ReadOnly:  true,
+		},
+	}
+
+	if registry.DockerConfigSecretRef != nil {
+		volumeMounts = append(volumeMounts, v1.VolumeMount{
+			Name:      "registry-docker-config",
+			MountPath: "/home/user/.docker",
+			ReadOnly:  true,
+		})
+	}
+	if registry.CACertificateConfigMapKeyRef != nil {
+		volumeMounts = append(volumeMounts, v1.VolumeMount{
+			Name:      "registry-ca-certificate",
+			MountPath: "/home/user/.config/buildkit/registry",
+			ReadOnly:  true,
+		})
+	}
+
+	return v1.Container{
+		Name:            b.name(),
+		Image:           b.image,
+		ImagePullPolicy: v1.PullIfNotPresent,
+		Command:         []string{"buildctl-daemonless.sh"},
+		Args:            args,
+		Env: []v1.EnvVar{
+			{
+				Name:  "BUILDKITD_FLAGS",
+				Value: "--oci-worker-no-process-sandbox",
+			},
+		},
+		VolumeMounts: volumeMounts,
+		SecurityContext: &v1.SecurityContext{
+			RunAsUser:  func(i int64) *int64 { return &i }(1000),
+			RunAsGroup: func(i int64) *int64 { return &i }(1000),
+			SeccompProfile: &coreV1.SeccompProfile{
+				Type: coreV1.SeccompProfileTypeUnconfined,
+			},
+		},
+	}
+}
+
+func (b *buildkitBuilder) workspace(runner *garV1.Runner, destination string, registry garV1.Registry) map[string]string {
+	config := fmt.Sprintf("[registry.%q]\n", strings.SplitN(destination, "/", 2)[0])
+	if registryInsecure(registry) {
+		config += "  http = true\n"
+		config += "  insecure = true\n"
+	}
+	if registry.CACertificateConfigMapKeyRef != nil {
+		config += "  ca = [\"/home/user/.config/buildkit/registry/ca.crt\"]\n"
+	}
+	return map[string]string{
+		"buildkitd.toml": config,
+	}
+}
+
+func (b *buildkitBuilder) annotations() map[string]string {
+	return map[string]string{
+		"container.apparmor.security.beta.kubernetes.io/" + b.name(): "unconfined",
+	}
+}
+
+func (b *buildkitBuilder) pushedDigest(message string) string {
+	metadata := struct {
+		Digest string `json:"containerimage.digest"`
+	}{}
+	if err := json.Unmarshal([]byte(message), &metadata); err != nil {
+		return ""
+	}
+	return metadata.Digest
+}
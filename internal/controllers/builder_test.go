@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	"testing"
+)
+
+func TestPushedDigest(t *testing.T) {
+	type in struct {
+		builder imageBuilder
+		message string
+	}
+
+	type want struct {
+		digest string
+	}
+
+	cases := []struct {
+		name string
+		in   in
+		want want
+	}{
+		{
+			"reads the digest file of kaniko",
+			in{
+				&kanikoBuilder{},
+				"sha256:0123456789abcdef\n",
+			},
+			want{
+				"sha256:0123456789abcdef",
+			},
+		},
+		{
+			"ignores messages of kaniko which are not digests",
+			in{
+				&kanikoBuilder{},
+				"error pushing image",
+			},
+			want{
+				"",
+			},
+		},
+		{
+			"reads the metadata file of buildkit",
+			in{
+				&buildkitBuilder{},
+				`{"containerimage.config.digest":"sha256:fedcba9876543210","containerimage.digest":"sha256:0123456789abcdef"}`,
+			},
+			want{
+				"sha256:0123456789abcdef",
+			},
+		},
+		{
+			"ignores messages of buildkit which are not metadata",
+			in{
+				&buildkitBuilder{},
+				"error: failed to solve",
+			},
+			want{
+				"",
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := tc.in.builder.pushedDigest(tc.in.message); got != tc.want.digest {
+				t.Errorf("digest: want %q, got %q", tc.want.digest, got)
+			}
+		})
+	}
+}
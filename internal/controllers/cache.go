@@ -0,0 +1,354 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	garV1 "github-actions-runner-controller/api/v1"
+
+	appsV1 "k8s.io/api/apps/v1"
+	batchV1 "k8s.io/api/batch/v1"
+	coreV1 "k8s.io/api/core/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	toolCachePath   = "/opt/hostedtoolcache"
+	workPath        = "/home/runner/_work"
+	runnerGroupID   = 60000
+	statefulSetKind = "StatefulSet"
+)
+
+// applyCache mounts cache volumes into the runner container, and makes the runner prune its work folder.
+func applyCache(runner *garV1.Runner, c *v1.Container) {
+	if toolCache := runner.Spec.Cache.ToolCache; toolCache != nil {
+		c.Env = append(c.Env, []v1.EnvVar{
+			{
+				Name:  "RUNNER_TOOL_CACHE",
+				Value: toolCachePath,
+			},
+			{
+				Name:  "AGENT_TOOLSDIRECTORY",
+				Value: toolCachePath,
+			},
+		}...)
+		c.VolumeMounts = append(c.VolumeMounts, v1.VolumeMount{
+			Name:      "tool-cache",
+			MountPath: toolCachePath,
+		})
+	}
+	if work := runner.Spec.Cache.Work; work != nil {
+		c.VolumeMounts = append(c.VolumeMounts, v1.VolumeMount{
+			Name:      "work",
+			MountPath: workPath,
+		})
+		if work.MaxAge != nil {
+			c.Args = append(c.Args, fmt.Sprintf("--prune-work-after=%s", work.MaxAge.Duration))
+		}
+	}
+}
+
+func buildCacheVolumes(runner *garV1.Runner) []v1.Volume {
+	if runner.Spec.Cache.ToolCache == nil {
+		return nil
+	}
+	return []v1.Volume{
+		{
+			Name: "tool-cache",
+			VolumeSource: v1.VolumeSource{
+				PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+					ClaimName: runner.Name + "-tool-cache",
+				},
+			},
+		},
+	}
+}
+
+// buildWorkVolumeClaimTemplates returns claims of the work folder created per runner pod by the StatefulSet.
+func buildWorkVolumeClaimTemplates(runner *garV1.Runner) []v1.PersistentVolumeClaim {
+	work := runner.Spec.Cache.Work
+	if work == nil {
+		return nil
+	}
+	return []v1.PersistentVolumeClaim{
+		{
+			ObjectMeta: metaV1.ObjectMeta{
+				Name: "work",
+			},
+			Spec: v1.PersistentVolumeClaimSpec{
+				AccessModes:      []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+				StorageClassName: work.StorageClassName,
+				Resources: v1.VolumeResourceRequirements{
+					Requests: v1.ResourceList{
+						v1.ResourceStorage: work.Size,
+					},
+				},
+			},
+		},
+	}
+}
+
+func buildToolCachePersistentVolumeClaim(runner *garV1.Runner) *v1.PersistentVolumeClaim {
+	toolCache := runner.Spec.Cache.ToolCache
+	return &v1.PersistentVolumeClaim{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      runner.Name + "-tool-cache",
+			Namespace: runner.Namespace,
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes:      []v1.PersistentVolumeAccessMode{v1.ReadWriteMany},
+			StorageClassName: toolCache.StorageClassName,
+			Resources: v1.VolumeResourceRequirements{
+				Requests: v1.ResourceList{
+					v1.ResourceStorage: toolCache.Size,
+				},
+			},
+		},
+	}
+}
+
+// reconcileToolCache creates the claim of the shared tool cache, and expands it when the requested size grows.
+func (r *RunnerReconciler) reconcileToolCache(ctx context.Context, runner *garV1.Runner) error {
+	if runner.Spec.Cache.ToolCache == nil {
+		return nil
+	}
+	logger := r.Log.WithValues("runner", client.ObjectKeyFromObject(runner))
+
+	expected := buildToolCachePersistentVolumeClaim(runner)
+	var claim v1.PersistentVolumeClaim
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(expected), &claim); apierrors.IsNotFound(err) {
+		if err := controllerutil.SetControllerReference(runner, expected, r.Scheme); err != nil {
+			return err
+		}
+		if err := r.Create(ctx, expected); err != nil {
+			return err
+		}
+		r.Recorder.Eventf(runner, coreV1.EventTypeNormal, "SuccessfulCreated", "Created persistent volume claim: %q", expected.Name)
+		logger.V(1).Info("create", "persistent volume claim", expected)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	size := expected.Spec.Resources.Requests[v1.ResourceStorage]
+	if size.Cmp(claim.Spec.Resources.Requests[v1.ResourceStorage]) <= 0 {
+		return nil
+	}
+	claim.Spec.Resources.Requests[v1.ResourceStorage] = size
+	if err := r.Update(ctx, &claim); err != nil {
+		return err
+	}
+	r.Recorder.Eventf(runner, coreV1.EventTypeNormal, "SuccessfulUpdated", "Expanded persistent volume claim: %q", claim.Name)
+	logger.V(1).Info("update", "persistent volume claim", claim)
+	return nil
+}
+
+func usesStatefulSet(runner *garV1.Runner) bool {
+	if runner.Spec.WorkloadKind != "" {
+		return runner.Spec.WorkloadKind == statefulSetKind
+	}
+	return runner.Spec.Cache.Work != nil
+}
+
+// buildStatefulSet returns the StatefulSet running the same pods as the deployment, giving each of them its own claims.
+func (r *RunnerReconciler) buildStatefulSet(runner *garV1.Runner) *appsV1.StatefulSet {
+	deployment := r.buildDeployment(runner)
+	return &appsV1.StatefulSet{
+		ObjectMeta: deployment.ObjectMeta,
+		Spec: appsV1.StatefulSetSpec{
+			Selector:            deployment.Spec.Selector,
+			Replicas:            deployment.Spec.Replicas,
+			ServiceName:         deployment.Name,
+			PodManagementPolicy: appsV1.ParallelPodManagement,
+			UpdateStrategy: appsV1.StatefulSetUpdateStrategy{
+				Type: appsV1.RollingUpdateStatefulSetStrategyType,
+			},
+			Template:             deployment.Spec.Template,
+			VolumeClaimTemplates: append(buildWorkVolumeClaimTemplates(runner), runner.Spec.VolumeClaimTemplates...),
+			PersistentVolumeClaimRetentionPolicy: &appsV1.StatefulSetPersistentVolumeClaimRetentionPolicy{
+				WhenDeleted: appsV1.DeletePersistentVolumeClaimRetentionPolicyType,
+				WhenScaled:  appsV1.RetainPersistentVolumeClaimRetentionPolicyType,
+			},
+		},
+	}
+}
+
+// reconcileStatefulSet creates the StatefulSet of runner pods, and updates its pod template and replicas.
+// Claim templates cannot be updated, so changes of them are only reported by an event until the StatefulSet is deleted.
+func (r *RunnerReconciler) reconcileStatefulSet(ctx context.Context, runner *garV1.Runner) error {
+	logger := r.Log.WithValues("runner", client.ObjectKeyFromObject(runner))
+
+	expected := r.buildStatefulSet(runner)
+	var statefulSet appsV1.StatefulSet
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(expected), &statefulSet); apierrors.IsNotFound(err) {
+		if err := controllerutil.SetControllerReference(runner, expected, r.Scheme); err != nil {
+			return err
+		}
+		if err := r.Create(ctx, expected); err != nil {
+			return err
+		}
+		r.Recorder.Eventf(runner, coreV1.EventTypeNormal, "SuccessfulCreated", "Created stateful set: %q", expected.Name)
+		logger.V(1).Info("create", "stateful set", expected)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if !claimTemplatesEqual(statefulSet.Spec.VolumeClaimTemplates, expected.Spec.VolumeClaimTemplates) {
+		r.Recorder.Eventf(runner, coreV1.EventTypeWarning, "ClaimTemplatesChanged", "Volume claim templates of stateful set %q cannot be updated, and apply once it is deleted with --cascade=orphan", statefulSet.Name)
+	}
+
+	replicasChanged := runner.Spec.Replicas != nil && !reflect.DeepEqual(statefulSet.Spec.Replicas, runner.Spec.Replicas)
+	if reflect.DeepEqual(statefulSet.Spec.Template, expected.Spec.Template) && !replicasChanged {
+		return nil
+	}
+	statefulSet.Spec.Template = expected.Spec.Template
+	if replicasChanged {
+		statefulSet.Spec.Replicas = runner.Spec.Replicas
+	}
+	if err := r.Update(ctx, &statefulSet); err != nil {
+		return err
+	}
+	r.Recorder.Eventf(runner, coreV1.EventTypeNormal, "SuccessfulUpdated", "Updated stateful set: %q", statefulSet.Name)
+	logger.V(1).Info("update", "stateful set", statefulSet)
+	return nil
+}
+
+// claimTemplatesEqual compares claim templates by the fields the controller sets, as the API server defaults the others.
+func claimTemplatesEqual(current []v1.PersistentVolumeClaim, expected []v1.PersistentVolumeClaim) bool {
+	if len(current) != len(expected) {
+		return false
+	}
+	for i := range expected {
+		currentSize := current[i].Spec.Resources.Requests[v1.ResourceStorage]
+		if current[i].Name != expected[i].Name ||
+			!reflect.DeepEqual(current[i].Spec.AccessModes, expected[i].Spec.AccessModes) ||
+			!reflect.DeepEqual(current[i].Spec.StorageClassName, expected[i].Spec.StorageClassName) ||
+			currentSize.Cmp(expected[i].Spec.Resources.Requests[v1.ResourceStorage]) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func buildToolCachePruningName(runner *garV1.Runner) string {
+	return runner.Name + "-tool-cache-pruning"
+}
+
+// buildToolCachePruningCronJob returns the CronJob pruning the shared tool cache hourly with the runner binary of the runner image,
+// so that the tool cache is pruned from one place instead of every runner pod.
+func (r *RunnerReconciler) buildToolCachePruningCronJob(runner *garV1.Runner) *batchV1.CronJob {
+	template := r.buildDeployment(runner).Spec.Template.Spec
+	c := v1.Container{
+		Name: "prune",
+		Args: []string{
+			"--prune-tool-cache=" + toolCachePath,
+			fmt.Sprintf("--prune-tool-cache-after=%s", runner.Spec.Cache.ToolCache.MaxAge.Duration),
+		},
+		VolumeMounts: []v1.VolumeMount{
+			{
+				Name:      "tool-cache",
+				MountPath: toolCachePath,
+			},
+		},
+		TerminationMessagePath:   coreV1.TerminationMessagePathDefault,
+		TerminationMessagePolicy: coreV1.TerminationMessageReadFile,
+	}
+	for _, container := range template.Containers {
+		if container.Name == "runner" {
+			c.Image = container.Image
+			c.ImagePullPolicy = container.ImagePullPolicy
+			c.SecurityContext = container.SecurityContext
+		}
+	}
+
+	name := buildToolCachePruningName(runner)
+	return &batchV1.CronJob{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      name,
+			Namespace: runner.Namespace,
+		},
+		Spec: batchV1.CronJobSpec{
+			Schedule:                   "0 * * * *",
+			ConcurrencyPolicy:          batchV1.ForbidConcurrent,
+			SuccessfulJobsHistoryLimit: func(i int32) *int32 { return &i }(1),
+			FailedJobsHistoryLimit:     func(i int32) *int32 { return &i }(1),
+			JobTemplate: batchV1.JobTemplateSpec{
+				Spec: batchV1.JobSpec{
+					BackoffLimit: func(i int32) *int32 { return &i }(0),
+					// A pod waiting for the runner image does not block the next run
+					ActiveDeadlineSeconds: func(i int64) *int64 { return &i }(50 * 60),
+					Template: v1.PodTemplateSpec{
+						ObjectMeta: metaV1.ObjectMeta{
+							Labels: map[string]string{
+								"app": name,
+							},
+						},
+						Spec: v1.PodSpec{
+							RestartPolicy:    v1.RestartPolicyNever,
+							Containers:       []v1.Container{c},
+							Volumes:          buildCacheVolumes(runner),
+							ImagePullSecrets: template.ImagePullSecrets,
+							NodeSelector:     template.NodeSelector,
+							Tolerations:      template.Tolerations,
+							SecurityContext:  template.SecurityContext,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// reconcileToolCachePruning creates the CronJob pruning the shared tool cache if its maxAge is set, and deletes it otherwise.
+func (r *RunnerReconciler) reconcileToolCachePruning(ctx context.Context, runner *garV1.Runner) error {
+	logger := r.Log.WithValues("runner", client.ObjectKeyFromObject(runner))
+
+	var cronJob batchV1.CronJob
+	err := r.Client.Get(ctx, client.ObjectKey{Name: buildToolCachePruningName(runner), Namespace: runner.Namespace}, &cronJob)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	exists := err == nil
+
+	if toolCache := runner.Spec.Cache.ToolCache; toolCache == nil || toolCache.MaxAge == nil {
+		if !exists || !metaV1.IsControlledBy(&cronJob, runner) {
+			return nil
+		}
+		if err := r.Client.Delete(ctx, &cronJob); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		r.Recorder.Eventf(runner, coreV1.EventTypeNormal, "SuccessfulDeleted", "Deleted cron job: %q", cronJob.Name)
+		return nil
+	}
+
+	expected := r.buildToolCachePruningCronJob(runner)
+	if !exists {
+		if err := controllerutil.SetControllerReference(runner, expected, r.Scheme); err != nil {
+			return err
+		}
+		if err := r.Create(ctx, expected); err != nil {
+			return err
+		}
+		r.Recorder.Eventf(runner, coreV1.EventTypeNormal, "SuccessfulCreated", "Created cron job: %q", expected.Name)
+		logger.V(1).Info("create", "cron job", expected)
+		return nil
+	}
+
+	if equality.Semantic.DeepDerivative(expected.Spec, cronJob.Spec) {
+		return nil
+	}
+	cronJob.Spec = expected.Spec
+	if err := r.Update(ctx, &cronJob); err != nil {
+		return err
+	}
+	r.Recorder.Eventf(runner, coreV1.EventTypeNormal, "SuccessfulUpdated", "Updated cron job: %q", cronJob.Name)
+	logger.V(1).Info("update", "cron job", cronJob)
+	return nil
+}
@@ -0,0 +1,253 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	garV1 "github-actions-runner-controller/api/v1"
+
+	"golang.org/x/xerrors"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// gitHubAPIURL is the base URL of the GitHub REST API.
+var gitHubAPIURL = "https://api.github.com"
+
+type workflowJob struct {
+	ID          int64      `json:"id"`
+	Status      string     `json:"status"`
+	Conclusion  string     `json:"conclusion"`
+	CompletedAt *time.Time `json:"completed_at"`
+	RunnerName  string     `json:"runner_name"`
+	Labels      []string   `json:"labels"`
+}
+
+// getGitHubToken returns the GitHub token which runner pods of the runner use.
+func (r *RunnerReconciler) getGitHubToken(ctx context.Context, runner *garV1.Runner) (string, error) {
+	return getSecretToken(ctx, r, runner.Namespace, runner.Spec.TokenSecretKeyRef)
+}
+
+func getSecretToken(ctx context.Context, c client.Reader, namespace string, tokenSecretKeyRef *v1.SecretKeySelector) (string, error) {
+	if tokenSecretKeyRef == nil {
+		return "", xerrors.New("no token secret")
+	}
+
+	var secret v1.Secret
+	if err := c.Get(
+		ctx,
+		client.ObjectKey{
+			Name:      tokenSecretKeyRef.Name,
+			Namespace: namespace,
+		},
+		&secret,
+	); err != nil {
+		return "", xerrors.Errorf("failed to get token secret: %w", err)
+	}
+
+	token, ok := secret.Data[tokenSecretKeyRef.Key]
+	if !ok {
+		return "", xerrors.Errorf("failed to find %s in token secret", tokenSecretKeyRef.Key)
+	}
+	return string(token), nil
+}
+
+func getGitHub(ctx context.Context, token string, endpoint string, path string, query url.Values, v interface{}) error {
+	request, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s%s?%s", gitHubAPIURL, path, query.Encode()), nil)
+	if err != nil {
+		return xerrors.Errorf("failed to create request: %w", err)
+	}
+	request.Header.Set("Accept", "application/vnd.github+json")
+	request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	request.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	response, err := doGitHubRequest(request, endpoint)
+	if err != nil {
+		return xerrors.Errorf("failed to do request: %w", err)
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	if response.StatusCode != http.StatusOK {
+		return xerrors.Errorf("failed to get %s: %d", path, response.StatusCode)
+	}
+
+	if err := json.NewDecoder(response.Body).Decode(v); err != nil {
+		return xerrors.Errorf("failed to decode %s: %w", path, err)
+	}
+	return nil
+}
+
+// listWorkflowRunIDs lists IDs of workflow runs of the repository matching the query.
+func listWorkflowRunIDs(ctx context.Context, token string, repository string, query url.Values) ([]int64, error) {
+	var ids []int64
+	for page := 1; ; page++ {
+		response := struct {
+			TotalCount   int `json:"total_count"`
+			WorkflowRuns []struct {
+				ID int64 `json:"id"`
+			} `json:"workflow_runs"`
+		}{}
+		query.Set("per_page", "100")
+		query.Set("page", strconv.Itoa(page))
+		if err := getGitHub(ctx, token, "runs", fmt.Sprintf("/repos/%s/actions/runs", repository), query, &response); err != nil {
+			return nil, err
+		}
+		for _, run := range response.WorkflowRuns {
+			ids = append(ids, run.ID)
+		}
+		if len(response.WorkflowRuns) == 0 || len(ids) >= response.TotalCount {
+			return ids, nil
+		}
+	}
+}
+
+// listWorkflowRunJobs lists jobs of the workflow run matching the query.
+func listWorkflowRunJobs(ctx context.Context, token string, repository string, runID int64, query url.Values) ([]workflowJob, error) {
+	var jobs []workflowJob
+	for page := 1; ; page++ {
+		response := struct {
+			TotalCount int           `json:"total_count"`
+			Jobs       []workflowJob `json:"jobs"`
+		}{}
+		query.Set("per_page", "100")
+		query.Set("page", strconv.Itoa(page))
+		if err := getGitHub(ctx, token, "jobs", fmt.Sprintf("/repos/%s/actions/runs/%d/jobs", repository, runID), query, &response); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, response.Jobs...)
+		if len(response.Jobs) == 0 || len(jobs) >= response.TotalCount {
+			return jobs, nil
+		}
+	}
+}
+
+// listQueuedWorkflowJobs lists jobs of the repository waiting for runners, including those of workflow runs already in progress.
+func listQueuedWorkflowJobs(ctx context.Context, token string, repository string) ([]workflowJob, error) {
+	var jobs []workflowJob
+	for _, status := range []string{"queued", "in_progress"} {
+		runIDs, err := listWorkflowRunIDs(ctx, token, repository, url.Values{
+			"status": {status},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, runID := range runIDs {
+			runJobs, err := listWorkflowRunJobs(ctx, token, repository, runID, url.Values{
+				"filter": {"latest"},
+			})
+			if err != nil {
+				return nil, err
+			}
+			for _, job := range runJobs {
+				if job.Status == "queued" {
+					jobs = append(jobs, job)
+				}
+			}
+		}
+	}
+	return jobs, nil
+}
+
+type repositoryRunner struct {
+	ID     int64  `json:"id"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Busy   bool   `json:"busy"`
+	Labels []struct {
+		Name string `json:"name"`
+	} `json:"labels"`
+}
+
+// listRepositoryRunners lists self-hosted runners registered to the repository.
+func listRepositoryRunners(ctx context.Context, token string, repository string) ([]repositoryRunner, error) {
+	var runners []repositoryRunner
+	for page := 1; ; page++ {
+		response := struct {
+			TotalCount int                `json:"total_count"`
+			Runners    []repositoryRunner `json:"runners"`
+		}{}
+		if err := getGitHub(ctx, token, "runners", fmt.Sprintf("/repos/%s/actions/runners", repository), url.Values{
+			"per_page": {"100"},
+			"page":     {strconv.Itoa(page)},
+		}, &response); err != nil {
+			return nil, err
+		}
+		runners = append(runners, response.Runners...)
+		if len(response.Runners) == 0 || len(runners) >= response.TotalCount {
+			return runners, nil
+		}
+	}
+}
+
+// deleteRepositoryRunner removes the self-hosted runner from the repository.
+func deleteRepositoryRunner(ctx context.Context, token string, repository string, id int64) error {
+	request, err := http.NewRequestWithContext(ctx, "DELETE", fmt.Sprintf("%s/repos/%s/actions/runners/%d", gitHubAPIURL, repository, id), nil)
+	if err != nil {
+		return xerrors.Errorf("failed to create request: %w", err)
+	}
+	request.Header.Set("Accept", "application/vnd.github+json")
+	request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	request.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	response, err := doGitHubRequest(request, "runner")
+	if err != nil {
+		return xerrors.Errorf("failed to do request: %w", err)
+	}
+	_ = response.Body.Close()
+
+	if response.StatusCode != http.StatusNoContent && response.StatusCode != http.StatusNotFound {
+		return xerrors.Errorf("failed to delete runner %d: %d", id, response.StatusCode)
+	}
+	return nil
+}
+
+type repository struct {
+	FullName string   `json:"full_name"`
+	Archived bool     `json:"archived"`
+	Topics   []string `json:"topics"`
+}
+
+// listInstallationRepositories lists repositories accessible by the installation access token.
+func listInstallationRepositories(ctx context.Context, token string) ([]repository, error) {
+	var repositories []repository
+	for page := 1; ; page++ {
+		response := struct {
+			TotalCount   int          `json:"total_count"`
+			Repositories []repository `json:"repositories"`
+		}{}
+		if err := getGitHub(ctx, token, "repositories", "/installation/repositories", url.Values{
+			"per_page": {"100"},
+			"page":     {strconv.Itoa(page)},
+		}, &response); err != nil {
+			return nil, err
+		}
+		repositories = append(repositories, response.Repositories...)
+		if len(response.Repositories) == 0 || len(repositories) >= response.TotalCount {
+			return repositories, nil
+		}
+	}
+}
+
+// listUserRepositories lists repositories accessible by the user of the token.
+func listUserRepositories(ctx context.Context, token string) ([]repository, error) {
+	var repositories []repository
+	for page := 1; ; page++ {
+		var response []repository
+		if err := getGitHub(ctx, token, "repositories", "/user/repos", url.Values{
+			"per_page": {"100"},
+			"page":     {strconv.Itoa(page)},
+		}, &response); err != nil {
+			return nil, err
+		}
+		repositories = append(repositories, response...)
+		if len(response) < 100 {
+			return repositories, nil
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package controllers
+
+import (
+	"fmt"
+
+	garV1 "github-actions-runner-controller/api/v1"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	hooksPath                = "/etc/runner-hooks"
+	defaultHookTimeout       = 300
+	defaultHookFailurePolicy = "Fail"
+)
+
+type namedHook struct {
+	name string
+	hook *garV1.Hook
+}
+
+// runnerHooks returns hooks of the runner container named after flags of the runner binary, in a stable order.
+func runnerHooks(runner *garV1.Runner) []namedHook {
+	var hooks []namedHook
+	if hook := runner.Spec.RunnerContainerSpec.Hooks.JobStarted; hook != nil {
+		hooks = append(hooks, namedHook{name: "job-started", hook: hook})
+	}
+	if hook := runner.Spec.RunnerContainerSpec.Hooks.JobCompleted; hook != nil {
+		hooks = append(hooks, namedHook{name: "job-completed", hook: hook})
+	}
+	return hooks
+}
+
+func buildHookArgs(runner *garV1.Runner) []string {
+	var args []string
+	for _, h := range runnerHooks(runner) {
+		timeout := h.hook.TimeoutSeconds
+		if timeout == 0 {
+			timeout = defaultHookTimeout
+		}
+		failurePolicy := h.hook.FailurePolicy
+		if failurePolicy == "" {
+			failurePolicy = defaultHookFailurePolicy
+		}
+		args = append(args,
+			fmt.Sprintf("--%s-hook=%s/%s/hook.sh", h.name, hooksPath, h.name),
+			fmt.Sprintf("--%s-hook-timeout=%ds", h.name, timeout),
+			fmt.Sprintf("--%s-hook-failure-policy=%s", h.name, failurePolicy),
+		)
+	}
+	return args
+}
+
+func buildHookVolumeMounts(runner *garV1.Runner) []v1.VolumeMount {
+	var volumeMounts []v1.VolumeMount
+	for _, h := range runnerHooks(runner) {
+		volumeMounts = append(volumeMounts, v1.VolumeMount{
+			Name:      "hook-" + h.name,
+			MountPath: fmt.Sprintf("%s/%s", hooksPath, h.name),
+			ReadOnly:  true,
+		})
+	}
+	return volumeMounts
+}
+
+func buildHookVolumes(runner *garV1.Runner) []v1.Volume {
+	var volumes []v1.Volume
+	for _, h := range runnerHooks(runner) {
+		volumes = append(volumes, v1.Volume{
+			Name: "hook-" + h.name,
+			VolumeSource: v1.VolumeSource{
+				ConfigMap: &v1.ConfigMapVolumeSource{
+					LocalObjectReference: h.hook.ConfigMapKeyRef.LocalObjectReference,
+					Items: []v1.KeyToPath{
+						{
+							Key:  h.hook.ConfigMapKeyRef.Key,
+							Path: "hook.sh",
+						},
+					},
+					DefaultMode: func(i int32) *int32 {
+						return &i
+					}(493),
+				},
+			},
+		})
+	}
+	return volumes
+}
@@ -0,0 +1,251 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	garV1 "github-actions-runner-controller/api/v1"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsV1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const metricsNamespace = "github_actions_runner_controller"
+
+var (
+	tokenMintDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "token_mint_duration_seconds",
+		Help:      "Latency of minting installation access tokens of the GitHub App",
+	})
+	tokenMintFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "token_mint_failures_total",
+		Help:      "Number of failures to mint installation access tokens of the GitHub App",
+	})
+	gitHubAPIRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "github_api_requests_total",
+		Help:      "Number of requests to the GitHub API by endpoint and status code",
+	}, []string{"endpoint", "code"})
+	gitHubAPIRateLimitRemaining = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "github_api_rate_limit_remaining",
+		Help:      "Remaining rate limit of the GitHub API reported by the last response",
+	})
+	buildDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "build_duration_seconds",
+		Help:      "Duration of init containers building runner images by result",
+		Buckets:   []float64{30, 60, 120, 300, 600, 1200, 1800, 3600},
+	}, []string{"namespace", "runner", "result"})
+	builds = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "builds_total",
+		Help:      "Number of builds of runner images by result",
+	}, []string{"namespace", "runner", "result"})
+	desiredRunners = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "desired_runners",
+		Help:      "Number of runner pods desired by deployments of the runner",
+	}, []string{"namespace", "runner"})
+	readyRunners = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "ready_runners",
+		Help:      "Number of ready runner pods of the runner",
+	}, []string{"namespace", "runner"})
+	busyRunners = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "busy_runners",
+		Help:      "Number of runner pods of the runner executing jobs",
+	}, []string{"namespace", "runner"})
+	orphanRunnersDeleted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "orphan_runners_deleted_total",
+		Help:      "Number of offline GitHub runners left behind by deleted pods and removed from the repository",
+	}, []string{"namespace", "runner"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		tokenMintDuration,
+		tokenMintFailures,
+		gitHubAPIRequests,
+		gitHubAPIRateLimitRemaining,
+		buildDuration,
+		builds,
+		desiredRunners,
+		readyRunners,
+		busyRunners,
+		orphanRunnersDeleted,
+	)
+}
+
+// doGitHubRequest sends the request to the GitHub API, recording it by endpoint, which must have low cardinality unlike paths.
+func doGitHubRequest(request *http.Request, endpoint string) (*http.Response, error) {
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		gitHubAPIRequests.WithLabelValues(endpoint, "error").Inc()
+		return nil, err
+	}
+	gitHubAPIRequests.WithLabelValues(endpoint, strconv.Itoa(response.StatusCode)).Inc()
+	if remaining, err := strconv.Atoi(response.Header.Get("X-RateLimit-Remaining")); err == nil {
+		gitHubAPIRateLimitRemaining.Set(float64(remaining))
+	}
+	return response, nil
+}
+
+func observeTokenMint(start time.Time, err error) {
+	tokenMintDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		tokenMintFailures.Inc()
+	}
+}
+
+// recordRunnerCounts records desired and ready runner pods summed over deployments and stateful sets of the runner.
+func (r *RunnerReconciler) recordRunnerCounts(ctx context.Context, runner *garV1.Runner) error {
+	var deployments appsV1.DeploymentList
+	if err := r.List(
+		ctx,
+		&deployments,
+		client.InNamespace(runner.Namespace),
+		client.MatchingFields{ownerKey: runner.Name},
+	); err != nil {
+		return err
+	}
+
+	var desired, ready int32
+	for _, deployment := range deployments.Items {
+		if deployment.Spec.Replicas != nil {
+			desired += *deployment.Spec.Replicas
+		}
+		ready += deployment.Status.ReadyReplicas
+	}
+	var statefulSets appsV1.StatefulSetList
+	if err := r.List(
+		ctx,
+		&statefulSets,
+		client.InNamespace(runner.Namespace),
+		client.MatchingFields{ownerKey: runner.Name},
+	); err != nil {
+		return err
+	}
+	for _, statefulSet := range statefulSets.Items {
+		if statefulSet.Spec.Replicas != nil {
+			desired += *statefulSet.Spec.Replicas
+		}
+		ready += statefulSet.Status.ReadyReplicas
+	}
+	desiredRunners.WithLabelValues(runner.Namespace, runner.Name).Set(float64(desired))
+	readyRunners.WithLabelValues(runner.Namespace, runner.Name).Set(float64(ready))
+	return nil
+}
+
+// buildObservations remembers the last build counted per pod of each runner, so that builds are counted once however often runners are reconciled.
+type buildObservations struct {
+	mu         sync.Mutex
+	finishedAt map[types.NamespacedName]map[types.UID]time.Time
+}
+
+// record counts builds of the pods which finished since they were last recorded, and forgets pods no longer running.
+func (o *buildObservations) record(runner types.NamespacedName, pods []v1.Pod) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.finishedAt == nil {
+		o.finishedAt = map[types.NamespacedName]map[types.UID]time.Time{}
+	}
+	last := o.finishedAt[runner]
+	current := map[types.UID]time.Time{}
+	for i := range pods {
+		pod := &pods[i]
+
+		recorded, ok := last[pod.UID]
+		if !ok {
+			recorded = pod.CreationTimestamp.Time
+			if last == nil {
+				// Builds which finished before the controller started were counted by the previous process
+				recorded = time.Now()
+			}
+		}
+		current[pod.UID] = recorded
+		for _, build := range finishedBuildsOf(pod) {
+			if !build.finishedAt.After(recorded) {
+				continue
+			}
+			builds.WithLabelValues(runner.Namespace, runner.Name, build.result).Inc()
+			if build.duration > 0 {
+				buildDuration.WithLabelValues(runner.Namespace, runner.Name, build.result).Observe(build.duration.Seconds())
+			}
+			if build.finishedAt.After(current[pod.UID]) {
+				current[pod.UID] = build.finishedAt
+			}
+		}
+	}
+	o.finishedAt[runner] = current
+}
+
+func (o *buildObservations) forget(runner types.NamespacedName) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	delete(o.finishedAt, runner)
+}
+
+type finishedBuild struct {
+	result     string
+	finishedAt time.Time
+	duration   time.Duration
+}
+
+// finishedBuildsOf returns the failed attempts of init containers of the pod still known by their statuses, and the succeeded build once all of them succeeded.
+func finishedBuildsOf(pod *v1.Pod) []finishedBuild {
+	var finished []finishedBuild
+	succeeded := len(pod.Status.InitContainerStatuses) > 0
+	for _, status := range pod.Status.InitContainerStatuses {
+		if status.State.Terminated == nil || status.State.Terminated.ExitCode != 0 {
+			succeeded = false
+		}
+		for _, terminated := range []*v1.ContainerStateTerminated{status.LastTerminationState.Terminated, status.State.Terminated} {
+			if terminated == nil || terminated.ExitCode == 0 {
+				continue
+			}
+			finished = append(finished, finishedBuild{
+				result:     "failed",
+				finishedAt: terminated.FinishedAt.Time,
+				duration:   terminated.FinishedAt.Sub(terminated.StartedAt.Time),
+			})
+		}
+	}
+	if succeeded {
+		duration := buildDurationOf(pod)
+		var finishedAt time.Time
+		for _, status := range pod.Status.InitContainerStatuses {
+			if status.State.Terminated.FinishedAt.Time.After(finishedAt) {
+				finishedAt = status.State.Terminated.FinishedAt.Time
+			}
+		}
+		finished = append(finished, finishedBuild{
+			result:     "succeeded",
+			finishedAt: finishedAt,
+			duration:   duration,
+		})
+	}
+	return finished
+}
+
+func deleteRunnerMetrics(namespace string, name string) {
+	desiredRunners.DeleteLabelValues(namespace, name)
+	readyRunners.DeleteLabelValues(namespace, name)
+	busyRunners.DeleteLabelValues(namespace, name)
+	orphanRunnersDeleted.DeleteLabelValues(namespace, name)
+	buildDuration.DeletePartialMatch(prometheus.Labels{"namespace": namespace, "runner": name})
+	builds.DeletePartialMatch(prometheus.Labels{"namespace": namespace, "runner": name})
+}
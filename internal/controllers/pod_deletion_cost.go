@@ -0,0 +1,56 @@
+package controllers
+
+import (
+	"context"
+
+	garV1 "github-actions-runner-controller/api/v1"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	podDeletionCostAnnotation = "controller.kubernetes.io/pod-deletion-cost"
+	busyPodDeletionCost       = "100"
+	idlePodDeletionCost       = "0"
+)
+
+// updatePodDeletionCosts annotates runner pods with the deletion cost following the busy state of GitHub runners named after them,
+// so that the ReplicaSet removes idle runners first on scale-down.
+func (r *RunnerReconciler) updatePodDeletionCosts(ctx context.Context, runner *garV1.Runner, repositoryRunners []repositoryRunner) error {
+	pods, err := r.listRunnerPods(ctx, runner)
+	if err != nil {
+		return err
+	}
+
+	busy := map[string]bool{}
+	for _, repositoryRunner := range repositoryRunners {
+		busy[repositoryRunner.Name] = repositoryRunner.Busy
+	}
+
+	var busyCount int
+	for _, pod := range pods {
+		pod := pod
+
+		cost := idlePodDeletionCost
+		if busy[pod.Name] {
+			cost = busyPodDeletionCost
+			busyCount++
+		}
+		if pod.Annotations[podDeletionCostAnnotation] == cost {
+			continue
+		}
+
+		patch := client.MergeFrom(pod.DeepCopy())
+		if pod.Annotations == nil {
+			pod.Annotations = map[string]string{}
+		}
+		pod.Annotations[podDeletionCostAnnotation] = cost
+		if err := r.Patch(ctx, &pod, patch); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		r.Log.V(1).Info("patch", "pod", pod.Name, "deletion cost", cost)
+	}
+	busyRunners.WithLabelValues(runner.Namespace, runner.Name).Set(float64(busyCount))
+	return nil
+}
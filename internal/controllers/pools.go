@@ -0,0 +1,249 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"strings"
+
+	garV1 "github-actions-runner-controller/api/v1"
+
+	appsV1 "k8s.io/api/apps/v1"
+	autoscalingV2 "k8s.io/api/autoscaling/v2"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+func minReplicas(autoscaling *garV1.Autoscaling) int32 {
+	if autoscaling.MinReplicas != nil {
+		return *autoscaling.MinReplicas
+	}
+	return 1
+}
+
+// scalesByQueue reports whether the pool is scaled by queued jobs by the controller instead of a HorizontalPodAutoscaler.
+func scalesByQueue(pool *garV1.Pool) bool {
+	return pool.Autoscaling != nil && minReplicas(pool.Autoscaling) == 0
+}
+
+// poolDeploymentName returns the name of the deployment of the pool, also used by its HorizontalPodAutoscaler.
+func poolDeploymentName(runner *garV1.Runner, pool *garV1.Pool) string {
+	return runner.Name + "-runner-" + pool.Name
+}
+
+// buildPoolDeployment returns the deployment of the pool, which runs the same runner image as the other pools.
+// Pods of pools keep the app label of runner pods, and are told apart by the pool label.
+func (r *RunnerReconciler) buildPoolDeployment(runner *garV1.Runner, pool *garV1.Pool) *appsV1.Deployment {
+	deployment := r.buildDeployment(runner)
+	deployment.Name = poolDeploymentName(runner, pool)
+
+	matchLabels := map[string]string{
+		"pool": pool.Name,
+	}
+	for k, v := range deployment.Spec.Selector.MatchLabels {
+		matchLabels[k] = v
+	}
+	deployment.Spec.Selector.MatchLabels = matchLabels
+	labels := map[string]string{
+		"pool": pool.Name,
+	}
+	for k, v := range deployment.Spec.Template.Labels {
+		labels[k] = v
+	}
+	deployment.Spec.Template.Labels = labels
+
+	deployment.Spec.Replicas = pool.Replicas
+	if pool.Autoscaling != nil {
+		deployment.Spec.Replicas = func(i int32) *int32 {
+			return &i
+		}(minReplicas(pool.Autoscaling))
+	}
+	deployment.Spec.Template.Spec.NodeSelector = pool.NodeSelector
+	for i := range deployment.Spec.Template.Spec.Containers {
+		c := &deployment.Spec.Template.Spec.Containers[i]
+		if c.Name != "runner" {
+			continue
+		}
+		if pool.Resources != nil {
+			c.Resources = *pool.Resources
+		}
+		if len(pool.Labels) > 0 {
+			for j, arg := range c.Args {
+				if strings.HasPrefix(arg, "--labels=") {
+					c.Args[j] = arg + "," + strings.Join(poolLabels(pool), ",")
+				}
+			}
+		}
+	}
+	return deployment
+}
+
+func buildPoolHorizontalPodAutoscaler(runner *garV1.Runner, pool *garV1.Pool) *autoscalingV2.HorizontalPodAutoscaler {
+	metrics := pool.Autoscaling.Metrics
+	if len(metrics) == 0 {
+		// Set explicitly as the API server defaults it
+		metrics = []autoscalingV2.MetricSpec{
+			{
+				Type: autoscalingV2.ResourceMetricSourceType,
+				Resource: &autoscalingV2.ResourceMetricSource{
+					Name: coreV1.ResourceCPU,
+					Target: autoscalingV2.MetricTarget{
+						Type:               autoscalingV2.UtilizationMetricType,
+						AverageUtilization: func(i int32) *int32 { return &i }(80),
+					},
+				},
+			},
+		}
+	}
+	return &autoscalingV2.HorizontalPodAutoscaler{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      poolDeploymentName(runner, pool),
+			Namespace: runner.Namespace,
+		},
+		Spec: autoscalingV2.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingV2.CrossVersionObjectReference{
+				APIVersion: "apps/v1",
+				Kind:       "Deployment",
+				Name:       poolDeploymentName(runner, pool),
+			},
+			MinReplicas: func(i int32) *int32 {
+				return &i
+			}(minReplicas(pool.Autoscaling)),
+			MaxReplicas: pool.Autoscaling.MaxReplicas,
+			Metrics:     metrics,
+		},
+	}
+}
+
+// reconcilePools creates the deployment of each pool, and updates its pod template, and its replicas unless it is autoscaled.
+func (r *RunnerReconciler) reconcilePools(ctx context.Context, runner *garV1.Runner) error {
+	for i := range runner.Spec.Pools {
+		pool := &runner.Spec.Pools[i]
+		if err := r.reconcilePoolDeployment(ctx, runner, pool); err != nil {
+			return err
+		}
+		if pool.Autoscaling != nil && !scalesByQueue(pool) {
+			if err := r.reconcilePoolHorizontalPodAutoscaler(ctx, runner, pool); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *RunnerReconciler) reconcilePoolDeployment(ctx context.Context, runner *garV1.Runner, pool *garV1.Pool) error {
+	logger := r.Log.WithValues("runner", client.ObjectKeyFromObject(runner))
+
+	expected := r.buildPoolDeployment(runner, pool)
+	var deployment appsV1.Deployment
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(expected), &deployment); apierrors.IsNotFound(err) {
+		if err := controllerutil.SetControllerReference(runner, expected, r.Scheme); err != nil {
+			return err
+		}
+		if err := r.Create(ctx, expected); err != nil {
+			return err
+		}
+		r.Recorder.Eventf(runner, coreV1.EventTypeNormal, "SuccessfulCreated", "Created deployment: %q", expected.Name)
+		logger.V(1).Info("create", "deployment", expected)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	replicasChanged := pool.Autoscaling == nil && !reflect.DeepEqual(deployment.Spec.Replicas, expected.Spec.Replicas)
+	if reflect.DeepEqual(deployment.Spec.Template, expected.Spec.Template) && !replicasChanged {
+		return nil
+	}
+	deployment.Spec.Template = expected.Spec.Template
+	if pool.Autoscaling == nil {
+		deployment.Spec.Replicas = expected.Spec.Replicas
+	}
+	if err := r.Update(ctx, &deployment); err != nil {
+		return err
+	}
+	r.Recorder.Eventf(runner, coreV1.EventTypeNormal, "SuccessfulUpdated", "Updated deployment: %q", deployment.Name)
+	logger.V(1).Info("update", "deployment", deployment)
+	return nil
+}
+
+func (r *RunnerReconciler) reconcilePoolHorizontalPodAutoscaler(ctx context.Context, runner *garV1.Runner, pool *garV1.Pool) error {
+	logger := r.Log.WithValues("runner", client.ObjectKeyFromObject(runner))
+
+	expected := buildPoolHorizontalPodAutoscaler(runner, pool)
+	var autoscaler autoscalingV2.HorizontalPodAutoscaler
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(expected), &autoscaler); apierrors.IsNotFound(err) {
+		if err := controllerutil.SetControllerReference(runner, expected, r.Scheme); err != nil {
+			return err
+		}
+		if err := r.Create(ctx, expected); err != nil {
+			return err
+		}
+		r.Recorder.Eventf(runner, coreV1.EventTypeNormal, "SuccessfulCreated", "Created horizontal pod autoscaler: %q", expected.Name)
+		logger.V(1).Info("create", "horizontal pod autoscaler", expected)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	// Quantities of metric targets are compared semantically as the API server canonicalizes them
+	if equality.Semantic.DeepEqual(autoscaler.Spec.ScaleTargetRef, expected.Spec.ScaleTargetRef) &&
+		equality.Semantic.DeepEqual(autoscaler.Spec.MinReplicas, expected.Spec.MinReplicas) &&
+		autoscaler.Spec.MaxReplicas == expected.Spec.MaxReplicas &&
+		equality.Semantic.DeepEqual(autoscaler.Spec.Metrics, expected.Spec.Metrics) {
+		return nil
+	}
+	autoscaler.Spec.ScaleTargetRef = expected.Spec.ScaleTargetRef
+	autoscaler.Spec.MinReplicas = expected.Spec.MinReplicas
+	autoscaler.Spec.MaxReplicas = expected.Spec.MaxReplicas
+	autoscaler.Spec.Metrics = expected.Spec.Metrics
+	if err := r.Update(ctx, &autoscaler); err != nil {
+		return err
+	}
+	r.Recorder.Eventf(runner, coreV1.EventTypeNormal, "SuccessfulUpdated", "Updated horizontal pod autoscaler: %q", autoscaler.Name)
+	logger.V(1).Info("update", "horizontal pod autoscaler", autoscaler)
+	return nil
+}
+
+func poolLabels(pool *garV1.Pool) []string {
+	labels := make([]string, 0, len(pool.Labels))
+	for _, label := range pool.Labels {
+		labels = append(labels, string(label))
+	}
+	return labels
+}
+
+// ownedDeploymentNames returns names of the deployments the runner currently runs, which cleanupOwnedResources keeps.
+func ownedDeploymentNames(runner *garV1.Runner) map[string]struct{} {
+	names := map[string]struct{}{}
+	switch {
+	case usesStatefulSet(runner):
+	case len(runner.Spec.Pools) > 0:
+		for i := range runner.Spec.Pools {
+			names[poolDeploymentName(runner, &runner.Spec.Pools[i])] = struct{}{}
+		}
+	default:
+		names[runner.Name+"-runner"] = struct{}{}
+		// The canary deployment is retired by the rollout once its runners finish their jobs
+		if runner.Spec.Rollout.Canary != nil || runner.Status.Rollout != nil {
+			names[runner.Name+"-runner-canary"] = struct{}{}
+		}
+	}
+	return names
+}
+
+// ownedHorizontalPodAutoscalerNames returns names of the autoscalers of pools, which cleanupOwnedResources keeps.
+func ownedHorizontalPodAutoscalerNames(runner *garV1.Runner) map[string]struct{} {
+	names := map[string]struct{}{}
+	if usesStatefulSet(runner) {
+		return names
+	}
+	for i := range runner.Spec.Pools {
+		if runner.Spec.Pools[i].Autoscaling != nil && !scalesByQueue(&runner.Spec.Pools[i]) {
+			names[poolDeploymentName(runner, &runner.Spec.Pools[i])] = struct{}{}
+		}
+	}
+	return names
+}
@@ -0,0 +1,240 @@
+package controllers
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	garV1 "github-actions-runner-controller/api/v1"
+
+	"golang.org/x/xerrors"
+	coreV1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var challengeParameterPattern = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// registryClient accesses registries with the docker config credentials and the CA certificate of a registry configuration,
+// answering Basic and Bearer challenges of the registry token authentication.
+type registryClient struct {
+	httpClient *http.Client
+	auths      map[string]registryAuth
+}
+
+type registryAuth struct {
+	Auth     string `json:"auth"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// anonymousRegistryClient returns the client accessing registries without credentials.
+func anonymousRegistryClient() *registryClient {
+	return &registryClient{
+		httpClient: http.DefaultClient,
+	}
+}
+
+// newRegistryClient returns the client of the registry, whose Secret and ConfigMap are read from the namespace.
+func (r *RunnerReconciler) newRegistryClient(ctx context.Context, namespace string, registry garV1.Registry) (*registryClient, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{
+		InsecureSkipVerify: registryInsecure(registry),
+	}
+	registryClient := &registryClient{
+		httpClient: &http.Client{
+			Transport: transport,
+		},
+		auths: map[string]registryAuth{},
+	}
+
+	if registry.DockerConfigSecretRef != nil {
+		var secret coreV1.Secret
+		if err := r.Get(ctx, client.ObjectKey{Name: registry.DockerConfigSecretRef.Name, Namespace: namespace}, &secret); err != nil {
+			return nil, xerrors.Errorf("failed to get registry docker config %s: %w", registry.DockerConfigSecretRef.Name, err)
+		}
+		config := struct {
+			Auths map[string]registryAuth `json:"auths"`
+		}{}
+		if err := json.Unmarshal(secret.Data[coreV1.DockerConfigJsonKey], &config); err != nil {
+			return nil, xerrors.Errorf("failed to decode registry docker config %s: %w", registry.DockerConfigSecretRef.Name, err)
+		}
+		for server, auth := range config.Auths {
+			registryClient.auths[registryHost(server)] = auth
+		}
+	}
+
+	if registry.CACertificateConfigMapKeyRef != nil {
+		var configMap coreV1.ConfigMap
+		if err := r.Get(ctx, client.ObjectKey{Name: registry.CACertificateConfigMapKeyRef.Name, Namespace: namespace}, &configMap); err != nil {
+			return nil, xerrors.Errorf("failed to get registry CA certificate %s: %w", registry.CACertificateConfigMapKeyRef.Name, err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM([]byte(configMap.Data[registry.CACertificateConfigMapKeyRef.Key])) {
+			return nil, xerrors.Errorf("failed to find registry CA certificate in %s", registry.CACertificateConfigMapKeyRef.Name)
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+
+	return registryClient, nil
+}
+
+// scopedTo returns the client with only the credentials of the registry host.
+func (c *registryClient) scopedTo(host string) *registryClient {
+	scoped := &registryClient{
+		httpClient: c.httpClient,
+		auths:      map[string]registryAuth{},
+	}
+	if auth, ok := c.auths[host]; ok {
+		scoped.auths[host] = auth
+	}
+	return scoped
+}
+
+// registryHost returns the host of a server in docker config, which may be a URL such as https://index.docker.io/v1/.
+func registryHost(server string) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(server, "https://"), "http://")
+	host = strings.SplitN(host, "/", 2)[0]
+	if host == "docker.io" || host == "index.docker.io" {
+		return "registry-1.docker.io"
+	}
+	return host
+}
+
+func (a registryAuth) credentials() (string, string, bool) {
+	if a.Username != "" || a.Password != "" {
+		return a.Username, a.Password, true
+	}
+	decoded, err := base64.StdEncoding.DecodeString(a.Auth)
+	if err != nil {
+		return "", "", false
+	}
+	parts := strings.SplitN(string(decoded), ":", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// do sends the request, which must not have a body, and sends it again with credentials if the registry challenges it.
+func (c *registryClient) do(request *http.Request) (*http.Response, error) {
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to do request: %w", err)
+	}
+	if response.StatusCode != http.StatusUnauthorized {
+		return response, nil
+	}
+	challenge := response.Header.Get("WWW-Authenticate")
+	_ = response.Body.Close()
+
+	username, password, ok := c.auths[request.URL.Host].credentials()
+	authenticated := request.Clone(request.Context())
+	if strings.HasPrefix(challenge, "Basic ") {
+		if !ok {
+			return nil, xerrors.Errorf("failed to find credentials of registry %s", request.URL.Host)
+		}
+		authenticated.SetBasicAuth(username, password)
+	} else {
+		token, err := c.getToken(request.Context(), challenge, username, password, ok)
+		if err != nil {
+			return nil, err
+		}
+		authenticated.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	}
+
+	response, err = c.httpClient.Do(authenticated)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to do request: %w", err)
+	}
+	return response, nil
+}
+
+// getToken gets a token following a Bearer challenge of the registry token authentication, anonymously unless credentials are given.
+func (c *registryClient) getToken(ctx context.Context, challenge string, username string, password string, authenticated bool) (string, error) {
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		return "", xerrors.Errorf("unsupported challenge: %s", challenge)
+	}
+
+	parameters := map[string]string{}
+	for _, matches := range challengeParameterPattern.FindAllStringSubmatch(challenge, -1) {
+		parameters[matches[1]] = matches[2]
+	}
+	realm, ok := parameters["realm"]
+	if !ok {
+		return "", xerrors.Errorf("failed to find realm in challenge: %s", challenge)
+	}
+
+	query := url.Values{}
+	if service, ok := parameters["service"]; ok {
+		query.Set("service", service)
+	}
+	if scope, ok := parameters["scope"]; ok {
+		query.Set("scope", scope)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, "GET", realm+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", xerrors.Errorf("failed to create request: %w", err)
+	}
+	// Credentials are never sent in plain text to the realm, which may be another host than the registry
+	if authenticated && request.URL.Scheme == "https" {
+		request.SetBasicAuth(username, password)
+	}
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return "", xerrors.Errorf("failed to do request: %w", err)
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	if response.StatusCode != http.StatusOK {
+		return "", xerrors.Errorf("failed to get registry token: %d", response.StatusCode)
+	}
+
+	token := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(response.Body).Decode(&token); err != nil {
+		return "", xerrors.Errorf("failed to decode registry token: %w", err)
+	}
+	if token.Token != "" {
+		return token.Token, nil
+	}
+	return token.AccessToken, nil
+}
+
+// getManifestDigest gets the digest of the manifest.
+func (c *registryClient) getManifestDigest(ctx context.Context, manifestURL string) (string, error) {
+	request, err := http.NewRequestWithContext(ctx, "HEAD", manifestURL, nil)
+	if err != nil {
+		return "", xerrors.Errorf("failed to create request: %w", err)
+	}
+	request.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+	response, err := c.do(request)
+	if err != nil {
+		return "", err
+	}
+	_ = response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", xerrors.Errorf("failed to get manifest %s: %d", manifestURL, response.StatusCode)
+	}
+
+	digest := response.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", xerrors.Errorf("failed to get digest of %s", manifestURL)
+	}
+	return digest, nil
+}
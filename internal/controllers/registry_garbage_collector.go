@@ -0,0 +1,365 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	garV1 "github-actions-runner-controller/api/v1"
+
+	"golang.org/x/xerrors"
+	appsV1 "k8s.io/api/apps/v1"
+	coreV1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const unreferencedSinceKey = "unreferencedSince"
+
+var (
+	repositoryNamePattern = regexp.MustCompile("^[0-9a-f]{7}$")
+	nextLinkPattern       = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+	manifestMediaTypes    = []string{
+		"application/vnd.docker.distribution.manifest.v2+json",
+		"application/vnd.docker.distribution.manifest.list.v2+json",
+		"application/vnd.oci.image.manifest.v1+json",
+		"application/vnd.oci.image.index.v1+json",
+	}
+)
+
+// registryURL returns the base URL of the push registry accessed as insecure or not, and the path prefix of repositories built by the controller.
+func (r *RunnerReconciler) registryURL(insecure bool) (string, string) {
+	parts := strings.SplitN(r.PushRegistryHost, "/", 2)
+	host := parts[0]
+	prefix := ""
+	if len(parts) == 2 {
+		prefix = parts[1] + "/"
+	}
+
+	return fmt.Sprintf("%s://%s", registryScheme(insecure), host), prefix
+}
+
+// registryScheme returns the scheme to access a registry, which is plain HTTP only if the registry is configured as insecure like kaniko.
+func registryScheme(insecure bool) string {
+	if insecure {
+		return "http"
+	}
+	return "https"
+}
+
+func (r *RunnerReconciler) startRegistryGarbageCollection(ctx context.Context) error {
+	logger := r.Log.WithName("registry-garbage-collector")
+	unreferencedSince, err := r.loadUnreferencedSince(ctx)
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(r.RegistryGarbageCollectionInterval)
+	defer ticker.Stop()
+	for {
+		if err := r.collectRegistryGarbage(ctx, unreferencedSince); err != nil {
+			logger.Error(err, "failed to collect registry garbage")
+		}
+		if err := r.saveUnreferencedSince(ctx, unreferencedSince); err != nil {
+			// The times are saved again at the next collection
+			logger.Error(err, "failed to save times repositories became unreferenced")
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *RunnerReconciler) collectRegistryGarbage(ctx context.Context, unreferencedSince map[string]time.Time) error {
+	logger := r.Log.WithName("registry-garbage-collector")
+
+	var runners garV1.RunnerList
+	if err := r.List(ctx, &runners); err != nil {
+		return xerrors.Errorf("failed to list runners: %w", err)
+	}
+
+	baseURL, prefix := r.registryURL(r.RegistryInsecure)
+
+	inUse := map[string]struct{}{}
+	for i := range runners.Items {
+		// Images of runners whose class cannot be applied are kept by their workloads below
+		if err := r.applyRunnerClass(ctx, &runners.Items[i]); err != nil {
+			logger.Error(err, "failed to apply runner class", "runner", client.ObjectKeyFromObject(&runners.Items[i]))
+			continue
+		}
+		inUse[prefix+r.buildRepositoryName(&runners.Items[i])] = struct{}{}
+	}
+
+	// Workloads may still run an older image, such as the stable deployment during a canary rollout
+	var deployments appsV1.DeploymentList
+	if err := r.List(ctx, &deployments); err != nil {
+		return xerrors.Errorf("failed to list deployments: %w", err)
+	}
+	var statefulSets appsV1.StatefulSetList
+	if err := r.List(ctx, &statefulSets); err != nil {
+		return xerrors.Errorf("failed to list stateful sets: %w", err)
+	}
+	var templates []coreV1.PodTemplateSpec
+	for _, deployment := range deployments.Items {
+		if owner := metaV1.GetControllerOf(&deployment); owner != nil && owner.Kind == "Runner" {
+			templates = append(templates, deployment.Spec.Template)
+		}
+	}
+	for _, statefulSet := range statefulSets.Items {
+		if owner := metaV1.GetControllerOf(&statefulSet); owner != nil && owner.Kind == "Runner" {
+			templates = append(templates, statefulSet.Spec.Template)
+		}
+	}
+	for _, template := range templates {
+		for _, container := range template.Spec.Containers {
+			if strings.HasPrefix(container.Image, r.PullRegistryHost+"/") {
+				// Runner images may be pinned to their signed digests
+				repository := strings.SplitN(strings.TrimPrefix(container.Image, r.PullRegistryHost+"/"), "@", 2)[0]
+				inUse[prefix+repository] = struct{}{}
+			}
+		}
+	}
+
+	registryClient, err := r.newPushRegistryClient(ctx, runners.Items)
+	if err != nil {
+		return err
+	}
+	repositories, err := listRegistryRepositories(ctx, registryClient, baseURL)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	seen := map[string]struct{}{}
+	for _, repository := range repositories {
+		if !strings.HasPrefix(repository, prefix) || !repositoryNamePattern.MatchString(strings.TrimPrefix(repository, prefix)) {
+			continue
+		}
+		seen[repository] = struct{}{}
+
+		if _, ok := inUse[repository]; ok {
+			delete(unreferencedSince, repository)
+			continue
+		}
+
+		since, ok := unreferencedSince[repository]
+		if !ok {
+			unreferencedSince[repository] = now
+			logger.V(1).Info("unreferenced", "repository", repository)
+			continue
+		}
+		if now.Sub(since) < r.RegistryGarbageCollectionRetention {
+			continue
+		}
+
+		if err := deleteRegistryRepository(ctx, registryClient, baseURL, repository); err != nil {
+			logger.Error(err, "failed to delete repository", "repository", repository)
+			continue
+		}
+		delete(unreferencedSince, repository)
+		logger.Info("delete", "repository", repository)
+	}
+
+	for repository := range unreferencedSince {
+		if _, ok := seen[repository]; !ok {
+			delete(unreferencedSince, repository)
+		}
+	}
+
+	return nil
+}
+
+// loadUnreferencedSince reads the times repositories became unreferenced from the ConfigMap of --registry-garbage-collection-config-map,
+// so that their retention is not restarted by restarts and leader changes of the controller.
+func (r *RunnerReconciler) loadUnreferencedSince(ctx context.Context) (map[string]time.Time, error) {
+	unreferencedSince := map[string]time.Time{}
+	if r.RegistryGarbageCollectionConfigMap.Name == "" {
+		return unreferencedSince, nil
+	}
+
+	var configMap coreV1.ConfigMap
+	if err := r.Get(ctx, r.RegistryGarbageCollectionConfigMap, &configMap); apierrors.IsNotFound(err) {
+		return unreferencedSince, nil
+	} else if err != nil {
+		return nil, xerrors.Errorf("failed to get %s: %w", r.RegistryGarbageCollectionConfigMap, err)
+	}
+	if data, ok := configMap.Data[unreferencedSinceKey]; ok {
+		if err := json.Unmarshal([]byte(data), &unreferencedSince); err != nil {
+			return nil, xerrors.Errorf("failed to decode %s: %w", r.RegistryGarbageCollectionConfigMap, err)
+		}
+	}
+	return unreferencedSince, nil
+}
+
+// saveUnreferencedSince writes the times repositories became unreferenced to the ConfigMap of --registry-garbage-collection-config-map.
+func (r *RunnerReconciler) saveUnreferencedSince(ctx context.Context, unreferencedSince map[string]time.Time) error {
+	if r.RegistryGarbageCollectionConfigMap.Name == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(unreferencedSince)
+	if err != nil {
+		return xerrors.Errorf("failed to encode times repositories became unreferenced: %w", err)
+	}
+
+	var configMap coreV1.ConfigMap
+	if err := r.Get(ctx, r.RegistryGarbageCollectionConfigMap, &configMap); apierrors.IsNotFound(err) {
+		configMap = coreV1.ConfigMap{
+			ObjectMeta: metaV1.ObjectMeta{
+				Name:      r.RegistryGarbageCollectionConfigMap.Name,
+				Namespace: r.RegistryGarbageCollectionConfigMap.Namespace,
+			},
+			Data: map[string]string{
+				unreferencedSinceKey: string(data),
+			},
+		}
+		return r.Create(ctx, &configMap)
+	} else if err != nil {
+		return err
+	}
+	if configMap.Data[unreferencedSinceKey] == string(data) {
+		return nil
+	}
+	if configMap.Data == nil {
+		configMap.Data = map[string]string{}
+	}
+	configMap.Data[unreferencedSinceKey] = string(data)
+	return r.Update(ctx, &configMap)
+}
+
+// newPushRegistryClient returns the client of the push registry shared by Runners of all namespaces,
+// with the credentials and the CA certificate of the registry configuration of the first Runner which has them.
+func (r *RunnerReconciler) newPushRegistryClient(ctx context.Context, runners []garV1.Runner) (*registryClient, error) {
+	logger := r.Log.WithName("registry-garbage-collector")
+
+	for i := range runners {
+		registry := r.buildRegistry(&runners[i])
+		if registry.DockerConfigSecretRef == nil && registry.CACertificateConfigMapKeyRef == nil {
+			continue
+		}
+		registryClient, err := r.newRegistryClient(ctx, runners[i].Namespace, registry)
+		if err != nil {
+			logger.Error(err, "failed to configure registry client", "runner", client.ObjectKeyFromObject(&runners[i]))
+			continue
+		}
+		return registryClient, nil
+	}
+	return r.newRegistryClient(ctx, "", garV1.Registry{Insecure: &r.RegistryInsecure})
+}
+
+func listRegistryRepositories(ctx context.Context, registryClient *registryClient, baseURL string) ([]string, error) {
+	var repositories []string
+
+	next := baseURL + "/v2/_catalog?n=100"
+	for next != "" {
+		request, err := http.NewRequestWithContext(ctx, "GET", next, nil)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to create request: %w", err)
+		}
+		response, err := registryClient.do(request)
+		if err != nil {
+			return nil, err
+		}
+
+		catalog := struct {
+			Repositories []string `json:"repositories"`
+		}{}
+		err = func() error {
+			defer func() {
+				_ = response.Body.Close()
+			}()
+			if response.StatusCode != http.StatusOK {
+				return xerrors.Errorf("failed to get catalog: %d", response.StatusCode)
+			}
+			if err := json.NewDecoder(response.Body).Decode(&catalog); err != nil {
+				return xerrors.Errorf("failed to decode catalog: %w", err)
+			}
+			return nil
+		}()
+		if err != nil {
+			return nil, err
+		}
+		repositories = append(repositories, catalog.Repositories...)
+
+		next = ""
+		if matches := nextLinkPattern.FindStringSubmatch(response.Header.Get("Link")); matches != nil {
+			next = baseURL + matches[1]
+		}
+	}
+
+	return repositories, nil
+}
+
+func deleteRegistryRepository(ctx context.Context, registryClient *registryClient, baseURL string, repository string) error {
+	tagsRequest, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/v2/%s/tags/list", baseURL, repository), nil)
+	if err != nil {
+		return xerrors.Errorf("failed to create request: %w", err)
+	}
+	tagsResponse, err := registryClient.do(tagsRequest)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tagsResponse.Body.Close()
+	}()
+
+	if tagsResponse.StatusCode != http.StatusOK {
+		return xerrors.Errorf("failed to get tags: %d", tagsResponse.StatusCode)
+	}
+
+	tags := struct {
+		Tags []string `json:"tags"`
+	}{}
+	if err := json.NewDecoder(tagsResponse.Body).Decode(&tags); err != nil {
+		return xerrors.Errorf("failed to decode tags: %w", err)
+	}
+
+	for _, tag := range tags.Tags {
+		manifestRequest, err := http.NewRequestWithContext(ctx, "HEAD", fmt.Sprintf("%s/v2/%s/manifests/%s", baseURL, repository, tag), nil)
+		if err != nil {
+			return xerrors.Errorf("failed to create request: %w", err)
+		}
+		manifestRequest.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+		manifestResponse, err := registryClient.do(manifestRequest)
+		if err != nil {
+			return err
+		}
+		_ = manifestResponse.Body.Close()
+
+		if manifestResponse.StatusCode == http.StatusNotFound {
+			continue
+		}
+		if manifestResponse.StatusCode != http.StatusOK {
+			return xerrors.Errorf("failed to get manifest of %s: %d", tag, manifestResponse.StatusCode)
+		}
+
+		digest := manifestResponse.Header.Get("Docker-Content-Digest")
+		if digest == "" {
+			return xerrors.Errorf("failed to get digest of %s", tag)
+		}
+
+		deleteRequest, err := http.NewRequestWithContext(ctx, "DELETE", fmt.Sprintf("%s/v2/%s/manifests/%s", baseURL, repository, digest), nil)
+		if err != nil {
+			return xerrors.Errorf("failed to create request: %w", err)
+		}
+		deleteResponse, err := registryClient.do(deleteRequest)
+		if err != nil {
+			return err
+		}
+		_ = deleteResponse.Body.Close()
+
+		if deleteResponse.StatusCode != http.StatusAccepted && deleteResponse.StatusCode != http.StatusNotFound {
+			return xerrors.Errorf("failed to delete manifest %s: %d", digest, deleteResponse.StatusCode)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRegistryClientScopedTo(t *testing.T) {
+	type in struct {
+		challenge   string
+		credentials bool
+	}
+
+	type want struct {
+		err           bool
+		authorization string
+	}
+
+	cases := []struct {
+		name string
+		in   in
+		want want
+	}{
+		{
+			"sends credentials of the registry host to a Basic challenge",
+			in{
+				`Basic realm="registry"`,
+				true,
+			},
+			want{
+				false,
+				"Basic dXNlcjpwYXNzd29yZA==",
+			},
+		},
+		{
+			"never sends credentials of other hosts to a Basic challenge",
+			in{
+				`Basic realm="registry"`,
+				false,
+			},
+			want{
+				true,
+				"",
+			},
+		},
+		{
+			"gets an anonymous token without credentials of other hosts",
+			in{
+				`Bearer realm="{server}/token",service="registry",scope="repository:library/ubuntu:pull"`,
+				false,
+			},
+			want{
+				false,
+				"Bearer anonymous",
+			},
+		},
+		{
+			"never sends credentials in plain text to the realm",
+			in{
+				`Bearer realm="{server}/token",service="registry",scope="repository:library/ubuntu:pull"`,
+				true,
+			},
+			want{
+				false,
+				"Bearer anonymous",
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var authorizations []string
+			var server *httptest.Server
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/token" {
+					if r.Header.Get("Authorization") != "" {
+						t.Errorf("unexpected credentials sent to realm: %s", r.Header.Get("Authorization"))
+					}
+					_, _ = w.Write([]byte(`{"token":"anonymous"}`))
+					return
+				}
+				authorizations = append(authorizations, r.Header.Get("Authorization"))
+				if r.Header.Get("Authorization") == "" {
+					w.Header().Set("WWW-Authenticate", strings.ReplaceAll(tc.in.challenge, "{server}", server.URL))
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				w.Header().Set("Docker-Content-Digest", "sha256:0")
+			}))
+			defer server.Close()
+
+			serverURL, err := url.Parse(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			auths := map[string]registryAuth{
+				"push.example.com": {Username: "push", Password: "secret"},
+			}
+			if tc.in.credentials {
+				auths[serverURL.Host] = registryAuth{Auth: "dXNlcjpwYXNzd29yZA=="}
+			}
+			registryClient := (&registryClient{
+				httpClient: server.Client(),
+				auths:      auths,
+			}).scopedTo(serverURL.Host)
+
+			_, err = registryClient.getManifestDigest(context.Background(), server.URL+"/v2/library/ubuntu/manifests/22.04")
+			if tc.want.err {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			last := authorizations[len(authorizations)-1]
+			if last != tc.want.authorization {
+				t.Errorf("authorization: want %q, got %q", tc.want.authorization, last)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	garV1 "github-actions-runner-controller/api/v1"
+
+	coreV1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ownerLabel returns the label registered to GitHub runners of pods of the runner,
+// which tells them from runners of other Runners of the same repository, even in other namespaces.
+func ownerLabel(runner *garV1.Runner) string {
+	return fmt.Sprintf("kaidotdev/github-actions-runner-controller/%s/%s", runner.Namespace, runner.Name)
+}
+
+// repositoryRunnerLister lists GitHub runners registered to the repository of the runner at most once per reconcile,
+// so that scaling pools, retiring canaries and syncing runner pods share one listing.
+type repositoryRunnerLister struct {
+	reconciler *RunnerReconciler
+	runner     *garV1.Runner
+
+	listed  bool
+	token   string
+	runners []repositoryRunner
+	err     error
+}
+
+// list returns the GitHub token of the runner and the runners registered to its repository.
+func (l *repositoryRunnerLister) list(ctx context.Context) (string, []repositoryRunner, error) {
+	if !l.listed {
+		l.listed = true
+		l.token, l.err = l.reconciler.getGitHubToken(ctx, l.runner)
+		if l.err == nil {
+			l.runners, l.err = listRepositoryRunners(ctx, l.token, l.runner.Spec.Repository)
+		}
+	}
+	return l.token, l.runners, l.err
+}
+
+// syncRepositoryRunners updates deletion costs of runner pods and removes orphan runners from GitHub runners registered to the repository.
+func (r *RunnerReconciler) syncRepositoryRunners(ctx context.Context, runner *garV1.Runner, lister *repositoryRunnerLister) error {
+	token, repositoryRunners, err := lister.list(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := r.updatePodDeletionCosts(ctx, runner, repositoryRunners); err != nil {
+		return err
+	}
+	if r.DeleteOrphanRunners {
+		return r.deleteOrphanRunners(ctx, runner, token, repositoryRunners)
+	}
+	return nil
+}
+
+// deleteOrphanRunners removes offline GitHub runners registered by pods of the runner which no longer exist.
+// Runners are only removed when they carry the owner label of the runner, so that runners of other Runners or registered by hand are never touched.
+func (r *RunnerReconciler) deleteOrphanRunners(ctx context.Context, runner *garV1.Runner, token string, repositoryRunners []repositoryRunner) error {
+	label := ownerLabel(runner)
+	for _, repositoryRunner := range repositoryRunners {
+		if repositoryRunner.Status != "offline" || !hasLabel(repositoryRunner, label) {
+			continue
+		}
+
+		// Runners are named after their pods
+		var pod coreV1.Pod
+		if err := r.Get(ctx, client.ObjectKey{Name: repositoryRunner.Name, Namespace: runner.Namespace}, &pod); err == nil {
+			continue
+		} else if !apierrors.IsNotFound(err) {
+			return err
+		}
+
+		if err := deleteRepositoryRunner(ctx, token, runner.Spec.Repository, repositoryRunner.ID); err != nil {
+			return err
+		}
+		orphanRunnersDeleted.WithLabelValues(runner.Namespace, runner.Name).Inc()
+		r.Log.Info("delete", "orphan runner", repositoryRunner.Name)
+	}
+	return nil
+}
+
+func hasLabel(repositoryRunner repositoryRunner, name string) bool {
+	for _, label := range repositoryRunner.Labels {
+		if label.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	garV1 "github-actions-runner-controller/api/v1"
+
+	"github.com/go-logr/logr"
+	coreV1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestDeleteOrphanRunners(t *testing.T) {
+	type in struct {
+		status    string
+		label     string
+		podExists bool
+	}
+
+	type want struct {
+		deleted bool
+	}
+
+	cases := []struct {
+		name string
+		in   in
+		want want
+	}{
+		{
+			"deletes offline runners of the Runner whose pods no longer exist",
+			in{
+				"offline",
+				"kaidotdev/github-actions-runner-controller/default/runner",
+				false,
+			},
+			want{
+				true,
+			},
+		},
+		{
+			"keeps offline runners whose pods still exist",
+			in{
+				"offline",
+				"kaidotdev/github-actions-runner-controller/default/runner",
+				true,
+			},
+			want{
+				false,
+			},
+		},
+		{
+			"keeps online runners",
+			in{
+				"online",
+				"kaidotdev/github-actions-runner-controller/default/runner",
+				false,
+			},
+			want{
+				false,
+			},
+		},
+		{
+			"never deletes runners of other Runners",
+			in{
+				"offline",
+				"kaidotdev/github-actions-runner-controller/default/other",
+				false,
+			},
+			want{
+				false,
+			},
+		},
+		{
+			"never deletes runners registered by hand",
+			in{
+				"offline",
+				"self-hosted",
+				false,
+			},
+			want{
+				false,
+			},
+		},
+	}
+
+	var mutex sync.Mutex
+	deleted := map[string]bool{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		mutex.Lock()
+		deleted[r.URL.Path] = true
+		mutex.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(server.Close)
+
+	original := gitHubAPIURL
+	gitHubAPIURL = server.URL
+	t.Cleanup(func() {
+		gitHubAPIURL = original
+	})
+
+	for i, tc := range cases {
+		i, tc := i, tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			name := fmt.Sprintf("runner-%d", i)
+			builder := fake.NewClientBuilder()
+			if tc.in.podExists {
+				builder = builder.WithObjects(&coreV1.Pod{
+					ObjectMeta: metaV1.ObjectMeta{
+						Name:      name,
+						Namespace: "default",
+					},
+				})
+			}
+			reconciler := &RunnerReconciler{
+				Client: builder.Build(),
+				Log:    logr.Discard(),
+			}
+			runner := &garV1.Runner{
+				ObjectMeta: metaV1.ObjectMeta{
+					Name:      "runner",
+					Namespace: "default",
+				},
+				Spec: garV1.RunnerSpec{
+					Repository: "kaidotdev/github-actions-runner-controller",
+				},
+			}
+			orphan := repositoryRunner{
+				ID:     int64(i),
+				Name:   name,
+				Status: tc.in.status,
+			}
+			orphan.Labels = append(orphan.Labels, struct {
+				Name string `json:"name"`
+			}{tc.in.label})
+
+			if err := reconciler.deleteOrphanRunners(context.Background(), runner, "token", []repositoryRunner{orphan}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			mutex.Lock()
+			got := deleted[fmt.Sprintf("/repos/kaidotdev/github-actions-runner-controller/actions/runners/%d", i)]
+			mutex.Unlock()
+			if got != tc.want.deleted {
+				t.Errorf("deleted: want %t, got %t", tc.want.deleted, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,316 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+
+	garV1 "github-actions-runner-controller/api/v1"
+
+	"golang.org/x/xerrors"
+	appsV1 "k8s.io/api/apps/v1"
+	coreV1 "k8s.io/api/core/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	rolloutPhaseCanary     = "Canary"
+	rolloutPhasePromoted   = "Promoted"
+	rolloutPhaseRolledBack = "RolledBack"
+	canaryPollInterval     = time.Minute
+	// Jobs which finished shortly before a count may not be listed by GitHub yet, and are left to the next count
+	canaryCountDelay = 30 * time.Second
+)
+
+// rolloutCanary brings up the canary deployment with the expected pod template, and judges the change from jobs of the canary runners.
+// It returns whether the change is promoted to the stable deployment, and the time to check the canary runners again.
+func (r *RunnerReconciler) rolloutCanary(ctx context.Context, runner *garV1.Runner, expected *appsV1.Deployment, lister *repositoryRunnerLister) (bool, time.Duration, error) {
+	logger := r.Log.WithValues("runner", client.ObjectKeyFromObject(runner))
+	canary := runner.Spec.Rollout.Canary
+
+	hash, err := templateHash(&expected.Spec.Template)
+	if err != nil {
+		return false, 0, err
+	}
+
+	if runner.Status.Rollout == nil || runner.Status.Rollout.TemplateHash != hash {
+		runner.Status.Rollout = &garV1.RolloutStatus{
+			Phase:        rolloutPhaseCanary,
+			TemplateHash: hash,
+			StartedAt:    &metaV1.Time{Time: time.Now()},
+		}
+		if err := r.updateStatus(ctx, runner); err != nil {
+			return false, 0, err
+		}
+		r.Recorder.Eventf(runner, coreV1.EventTypeNormal, "CanaryStarted", "Started canary of pod template %s", hash)
+	}
+
+	switch runner.Status.Rollout.Phase {
+	case rolloutPhasePromoted:
+		pollAfter, err := r.retireCanaryDeployment(ctx, runner, lister)
+		return true, pollAfter, err
+	case rolloutPhaseRolledBack:
+		pollAfter, err := r.retireCanaryDeployment(ctx, runner, lister)
+		return false, pollAfter, err
+	}
+
+	expectedCanary := r.buildCanaryDeployment(runner, expected)
+	var deployment appsV1.Deployment
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(expectedCanary), &deployment); apierrors.IsNotFound(err) {
+		if err := controllerutil.SetControllerReference(runner, expectedCanary, r.Scheme); err != nil {
+			return false, 0, err
+		}
+		if err := r.Create(ctx, expectedCanary); err != nil {
+			return false, 0, err
+		}
+		r.Recorder.Eventf(runner, coreV1.EventTypeNormal, "SuccessfulCreated", "Created deployment: %q", expectedCanary.Name)
+		logger.V(1).Info("create", "deployment", expectedCanary)
+	} else if err != nil {
+		return false, 0, err
+	} else if !reflect.DeepEqual(deployment.Spec.Template, expectedCanary.Spec.Template) || *deployment.Spec.Replicas != *expectedCanary.Spec.Replicas {
+		deployment.Spec.Template = expectedCanary.Spec.Template
+		deployment.Spec.Replicas = expectedCanary.Spec.Replicas
+
+		if err := r.Update(ctx, &deployment); err != nil {
+			return false, 0, err
+		}
+		r.Recorder.Eventf(runner, coreV1.EventTypeNormal, "SuccessfulUpdated", "Updated deployment: %q", deployment.Name)
+		logger.V(1).Info("update", "deployment", deployment)
+	}
+
+	succeeded, failed, pendingRunIDs, countedUntil, err := r.countCanaryJobs(ctx, runner)
+	if err != nil {
+		// Jobs are counted again at the next poll
+		logger.Error(err, "failed to count jobs of canary runners")
+		return false, canaryPollInterval, nil
+	}
+	status := runner.Status.Rollout
+	status.SucceededJobs += succeeded
+	status.FailedJobs += failed
+	status.PendingRunIDs = pendingRunIDs
+	status.CountedUntil = &metaV1.Time{Time: countedUntil}
+	succeeded, failed = status.SucceededJobs, status.FailedJobs
+
+	promote := false
+	if failed > 0 && failed*100 > canary.MaxFailurePercentage*(succeeded+failed) {
+		status.Phase = rolloutPhaseRolledBack
+	} else if succeeded >= canary.SuccessfulJobs {
+		status.Phase = rolloutPhasePromoted
+		promote = true
+	}
+	if err := r.updateStatus(ctx, runner); err != nil {
+		return false, 0, err
+	}
+
+	switch runner.Status.Rollout.Phase {
+	case rolloutPhasePromoted:
+		r.Recorder.Eventf(runner, coreV1.EventTypeNormal, "CanaryPromoted", "Promoted pod template %s after %d successful jobs", hash, succeeded)
+	case rolloutPhaseRolledBack:
+		r.Recorder.Eventf(runner, coreV1.EventTypeWarning, "CanaryRolledBack", "Rolled back pod template %s after %d of %d jobs failed", hash, failed, succeeded+failed)
+	default:
+		return false, canaryPollInterval, nil
+	}
+	pollAfter, err := r.retireCanaryDeployment(ctx, runner, lister)
+	return promote, pollAfter, err
+}
+
+// finishCanary retires the canary deployment of a rollout left behind when the stable deployment already matches.
+// It returns the time to check the canary runners again if some of them are still running jobs.
+func (r *RunnerReconciler) finishCanary(ctx context.Context, runner *garV1.Runner, lister *repositoryRunnerLister) (time.Duration, error) {
+	if runner.Status.Rollout == nil {
+		return 0, nil
+	}
+	pollAfter, err := r.retireCanaryDeployment(ctx, runner, lister)
+	if err != nil || pollAfter > 0 || runner.Status.Rollout.Phase != rolloutPhaseCanary {
+		return pollAfter, err
+	}
+	runner.Status.Rollout = nil
+	return 0, r.updateStatus(ctx, runner)
+}
+
+// retireCanaryDeployment deletes the canary deployment once none of the canary runners is running a job.
+// Until then, the deployment is scaled down to the busy runners, which the ReplicaSet keeps by their pod deletion cost,
+// and the time to check them again is returned.
+func (r *RunnerReconciler) retireCanaryDeployment(ctx context.Context, runner *garV1.Runner, lister *repositoryRunnerLister) (time.Duration, error) {
+	logger := r.Log.WithValues("runner", client.ObjectKeyFromObject(runner))
+
+	var deployment appsV1.Deployment
+	if err := r.Client.Get(
+		ctx,
+		client.ObjectKey{
+			Name:      runner.Name + "-runner-canary",
+			Namespace: runner.Namespace,
+		},
+		&deployment,
+	); apierrors.IsNotFound(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	busy, err := countBusyCanaryRunners(ctx, runner, lister)
+	if err != nil {
+		// Runner pods still wait for their current jobs in the preStop hook within the termination grace period
+		logger.Error(err, "failed to count busy canary runners")
+		busy = 0
+	}
+	if busy > 0 {
+		if deployment.Spec.Replicas == nil || *deployment.Spec.Replicas > busy {
+			deployment.Spec.Replicas = &busy
+			if err := r.Update(ctx, &deployment); err != nil {
+				return 0, err
+			}
+			r.Recorder.Eventf(runner, coreV1.EventTypeNormal, "SuccessfulRescale", "Scaled deployment %q down to %d busy runners", deployment.Name, busy)
+		}
+		return canaryPollInterval, nil
+	}
+
+	if err := r.Client.Delete(ctx, &deployment); err != nil && !apierrors.IsNotFound(err) {
+		return 0, err
+	}
+	r.Recorder.Eventf(runner, coreV1.EventTypeNormal, "SuccessfulDeleted", "Deleted deployment: %q", deployment.Name)
+	return 0, nil
+}
+
+// countBusyCanaryRunners counts the canary runners running jobs.
+func countBusyCanaryRunners(ctx context.Context, runner *garV1.Runner, lister *repositoryRunnerLister) (int32, error) {
+	_, repositoryRunners, err := lister.list(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var busy int32
+	for _, repositoryRunner := range repositoryRunners {
+		if repositoryRunner.Busy && strings.HasPrefix(repositoryRunner.Name, runner.Name+"-runner-canary-") {
+			busy++
+		}
+	}
+	return busy, nil
+}
+
+// countCanaryJobs counts jobs finished by the canary runners since the last count, and returns them with the runs to look at again and the time counted up to.
+// Only runs queued or in progress now, runs with jobs left uncounted at the last count, and runs created since the last count can have such jobs,
+// so that the cost of a count does not grow with the activity of the repository over the canary.
+func (r *RunnerReconciler) countCanaryJobs(ctx context.Context, runner *garV1.Runner) (int32, int32, []int64, time.Time, error) {
+	token, err := r.getGitHubToken(ctx, runner)
+	if err != nil {
+		return 0, 0, nil, time.Time{}, err
+	}
+	status := runner.Status.Rollout
+	since := status.StartedAt.Time
+	if status.CountedUntil != nil {
+		since = status.CountedUntil.Time
+	}
+	until := time.Now().Add(-canaryCountDelay)
+	if until.Before(since) {
+		return 0, 0, status.PendingRunIDs, since, nil
+	}
+
+	runIDs := append([]int64{}, status.PendingRunIDs...)
+	for _, query := range []url.Values{
+		{"status": {"queued"}},
+		{"status": {"in_progress"}},
+		{"created": {">=" + since.UTC().Format(time.RFC3339)}},
+	} {
+		ids, err := listWorkflowRunIDs(ctx, token, runner.Spec.Repository, query)
+		if err != nil {
+			return 0, 0, nil, time.Time{}, err
+		}
+		runIDs = append(runIDs, ids...)
+	}
+
+	seen := map[int64]struct{}{}
+	var pendingRunIDs []int64
+	var succeeded, failed int32
+	for _, runID := range runIDs {
+		if _, ok := seen[runID]; ok {
+			continue
+		}
+		seen[runID] = struct{}{}
+
+		jobs, err := listWorkflowRunJobs(ctx, token, runner.Spec.Repository, runID, url.Values{
+			"filter": {"latest"},
+		})
+		if err != nil {
+			return 0, 0, nil, time.Time{}, err
+		}
+		pending := false
+		for _, job := range jobs {
+			if job.Status != "completed" || job.CompletedAt == nil || !job.CompletedAt.Before(until) {
+				// The run is looked at again until all of its jobs are counted
+				pending = true
+				continue
+			}
+			if job.CompletedAt.Before(since) || !strings.HasPrefix(job.RunnerName, runner.Name+"-runner-canary-") {
+				continue
+			}
+			switch job.Conclusion {
+			case "success":
+				succeeded++
+			case "failure", "timed_out":
+				failed++
+			}
+		}
+		if pending {
+			pendingRunIDs = append(pendingRunIDs, runID)
+		}
+	}
+	return succeeded, failed, pendingRunIDs, until, nil
+}
+
+// buildCanaryDeployment returns the deployment of canary runners running the expected pod template next to the stable deployment.
+func (r *RunnerReconciler) buildCanaryDeployment(runner *garV1.Runner, expected *appsV1.Deployment) *appsV1.Deployment {
+	deployment := expected.DeepCopy()
+	appLabel := runner.Name + "-runner-canary"
+
+	deployment.Name = appLabel
+	deployment.Spec.Selector.MatchLabels["app"] = appLabel
+	deployment.Spec.Template.ObjectMeta.Labels["app"] = appLabel
+	if affinity := deployment.Spec.Template.Spec.Affinity; affinity != nil && affinity.PodAntiAffinity != nil {
+		for _, term := range affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
+			term.PodAffinityTerm.LabelSelector.MatchLabels["app"] = appLabel
+		}
+	}
+	deployment.Spec.Replicas = func(i int32) *int32 {
+		return &i
+	}(runner.Spec.Rollout.Canary.Replicas)
+	return deployment
+}
+
+// listRunnerPods lists pods of both the stable and the canary deployments of the runner.
+func (r *RunnerReconciler) listRunnerPods(ctx context.Context, runner *garV1.Runner) ([]v1.Pod, error) {
+	requirement, err := labels.NewRequirement("app", selection.In, []string{runner.Name + "-runner", runner.Name + "-runner-canary"})
+	if err != nil {
+		return nil, err
+	}
+
+	var pods v1.PodList
+	if err := r.List(
+		ctx,
+		&pods,
+		client.InNamespace(runner.Namespace),
+		client.MatchingLabelsSelector{Selector: labels.NewSelector().Add(*requirement)},
+	); err != nil {
+		return nil, err
+	}
+	return pods.Items, nil
+}
+
+func templateHash(template *v1.PodTemplateSpec) (string, error) {
+	b, err := json.Marshal(template)
+	if err != nil {
+		return "", xerrors.Errorf("failed to marshal pod template: %w", err)
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(b))[:10], nil
+}
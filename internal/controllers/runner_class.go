@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+
+	garV1 "github-actions-runner-controller/api/v1"
+
+	"golang.org/x/xerrors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	runnerClassNameKey       = ".spec.runnerClassName"
+	enforceCredentialsPolicy = "Enforce"
+)
+
+// applyRunnerClass merges defaults of the runner class under values of the runner in memory.
+func (r *RunnerReconciler) applyRunnerClass(ctx context.Context, runner *garV1.Runner) error {
+	if runner.Spec.RunnerClassName == "" {
+		return nil
+	}
+
+	var runnerClass garV1.RunnerClass
+	if err := r.Get(ctx, client.ObjectKey{Name: runner.Spec.RunnerClassName}, &runnerClass); err != nil {
+		return xerrors.Errorf("failed to get runner class %s: %w", runner.Spec.RunnerClassName, err)
+	}
+	spec, err := mergeRunnerClass(&runnerClass, &runner.Spec)
+	if err != nil {
+		return err
+	}
+	runner.Spec = *spec
+	return nil
+}
+
+// mergeRunnerClass returns the spec patched onto defaults of the runner class by strategic merge,
+// so that lists are merged by their patch merge keys like kubectl apply.
+func mergeRunnerClass(runnerClass *garV1.RunnerClass, spec *garV1.RunnerSpec) (*garV1.RunnerSpec, error) {
+	defaults := garV1.RunnerSpec{
+		Image:                runnerClass.Spec.Image,
+		Template:             runnerClass.Spec.Template,
+		Build:                runnerClass.Spec.Build,
+		BuilderContainerSpec: runnerClass.Spec.BuilderContainerSpec,
+		RunnerContainerSpec:  runnerClass.Spec.RunnerContainerSpec,
+	}
+	original, err := json.Marshal(defaults)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to marshal runner class: %w", err)
+	}
+	patch, err := json.Marshal(spec)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to marshal runner: %w", err)
+	}
+	b, err := strategicpatch.StrategicMergePatch(original, patch, garV1.RunnerSpec{})
+	if err != nil {
+		return nil, xerrors.Errorf("failed to merge runner class: %w", err)
+	}
+
+	var merged garV1.RunnerSpec
+	if err := json.Unmarshal(b, &merged); err != nil {
+		return nil, xerrors.Errorf("failed to unmarshal merged spec: %w", err)
+	}
+	if runnerClass.Spec.CredentialsPolicy == enforceCredentialsPolicy || (spec.TokenSecretKeyRef == nil && spec.AppSecretRef == nil) {
+		merged.TokenSecretKeyRef = runnerClass.Spec.TokenSecretKeyRef
+		merged.AppSecretRef = runnerClass.Spec.AppSecretRef
+	}
+	return &merged, nil
+}
+
+// mapRunnerClassToRunners enqueues runners of the runner class so that changes of the class are rolled out to them.
+func (r *RunnerReconciler) mapRunnerClassToRunners(ctx context.Context, object client.Object) []reconcile.Request {
+	var runners garV1.RunnerList
+	if err := r.List(ctx, &runners, client.MatchingFields{runnerClassNameKey: object.GetName()}); err != nil {
+		r.Log.Error(err, "failed to list runners of runner class", "runnerclass", object.GetName())
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(runners.Items))
+	for _, runner := range runners.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{
+				Name:      runner.Name,
+				Namespace: runner.Namespace,
+			},
+		})
+	}
+	return requests
+}
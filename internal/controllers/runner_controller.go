@@ -20,18 +20,28 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/xerrors"
 	appsV1 "k8s.io/api/apps/v1"
+	autoscalingV2 "k8s.io/api/autoscaling/v2"
+	batchV1 "k8s.io/api/batch/v1"
 	coreV1 "k8s.io/api/core/v1"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	ctrlBuilder "sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 )
 
@@ -39,24 +49,76 @@ const (
 	ownerKey               = ".metadata.controller"
 	optimisticLockErrorMsg = "the object has been modified; please apply your changes to the latest version and try again"
 	expiresAtAnnotation    = "github-actions-runner.kaidotio.github.io/expiresAt"
+	defaultArchitecture    = "amd64"
 )
 
+var builderResourcePresets = map[string]v1.ResourceRequirements{
+	"small": {
+		Requests: v1.ResourceList{
+			v1.ResourceCPU:    resource.MustParse("500m"),
+			v1.ResourceMemory: resource.MustParse("1Gi"),
+		},
+		Limits: v1.ResourceList{
+			v1.ResourceMemory: resource.MustParse("2Gi"),
+		},
+	},
+	"medium": {
+		Requests: v1.ResourceList{
+			v1.ResourceCPU:    resource.MustParse("1"),
+			v1.ResourceMemory: resource.MustParse("2Gi"),
+		},
+		Limits: v1.ResourceList{
+			v1.ResourceMemory: resource.MustParse("4Gi"),
+		},
+	},
+	"large": {
+		Requests: v1.ResourceList{
+			v1.ResourceCPU:    resource.MustParse("2"),
+			v1.ResourceMemory: resource.MustParse("4Gi"),
+		},
+		Limits: v1.ResourceList{
+			v1.ResourceMemory: resource.MustParse("8Gi"),
+		},
+	},
+}
+
 type RunnerReconciler struct {
 	client.Client
-	Log                     logr.Logger
-	Scheme                  *runtime.Scheme
-	Recorder                record.EventRecorder
-	PushRegistryHost        string
-	PullRegistryHost        string
-	EnableRunnerMetrics     bool
-	ExporterImage           string
-	GitHubAppClientId       string
-	GitHubAppInstallationId string
-	GitHubAppPrivateKey     string
-	KanikoImage             string
-	BinaryVersion           string
-	RunnerVersion           string
-	Disableupdate           bool
+	KubernetesClient                   kubernetes.Interface
+	Log                                logr.Logger
+	Scheme                             *runtime.Scheme
+	Recorder                           record.EventRecorder
+	PushRegistryHost                   string
+	PullRegistryHost                   string
+	EnableRunnerMetrics                bool
+	GitHubAppClientId                  string
+	GitHubAppInstallationId            string
+	GitHubAppPrivateKey                string
+	Builder                            string
+	KanikoImage                        string
+	SyftImage                          string
+	CosignImage                        string
+	BuildKitImage                      string
+	BinaryVersion                      string
+	RunnerVersion                      string
+	RunnerDownloadURL                  string
+	BinaryDownloadURL                  string
+	Disableupdate                      bool
+	RegistryDockerConfigSecretName     string
+	RegistryInsecure                   bool
+	RegistryCACertificateConfigMapName string
+	RegistryGarbageCollectionInterval  time.Duration
+	RegistryGarbageCollectionRetention time.Duration
+	RegistryGarbageCollectionConfigMap types.NamespacedName
+	RunnerVersionRefreshInterval       time.Duration
+	PodDeletionCostInterval            time.Duration
+	DeleteOrphanRunners                bool
+	QueuePollInterval                  time.Duration
+	WebhookAddress                     string
+	WebhookSecret                      string
+
+	runnerVersionCache runnerVersionCache
+	buildObservations  buildObservations
 }
 
 func (r *RunnerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -66,15 +128,101 @@ func (r *RunnerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 	logger := r.Log.WithValues("runner", req.NamespacedName)
 	if err := r.Get(ctx, req.NamespacedName, runner); err != nil {
 		if apierrors.IsNotFound(err) {
+			deleteRunnerMetrics(req.Namespace, req.Name)
+			r.buildObservations.forget(req.NamespacedName)
 			return ctrl.Result{}, nil
 		}
 		return ctrl.Result{}, err
 	}
 
+	if err := r.applyRunnerClass(ctx, runner); err != nil {
+		r.Recorder.Eventf(runner, coreV1.EventTypeWarning, "FailedApplyRunnerClass", "Failed to apply runner class %q: %v", runner.Spec.RunnerClassName, err)
+		return ctrl.Result{}, err
+	}
+	if runner.Spec.Image == "" {
+		r.Recorder.Eventf(runner, coreV1.EventTypeWarning, "FailedApplyRunnerClass", "Neither runner nor runner class %q specifies image", runner.Spec.RunnerClassName)
+		return ctrl.Result{}, nil
+	}
+
+	activeSchedules, scheduleChangeAt, err := applySchedules(runner, time.Now())
+	if err != nil {
+		r.Recorder.Eventf(runner, coreV1.EventTypeWarning, "FailedSchedule", "Failed to apply schedules: %v", err)
+		logger.Error(err, "failed to apply schedules")
+	}
+	if !scheduleChangeAt.IsZero() {
+		requeueAfter = shorterRequeueAfter(requeueAfter, time.Until(scheduleChangeAt))
+	}
+	// Times are compared semantically as those read back from the API server are in the local time zone
+	if !equality.Semantic.DeepEqual(runner.Status.ActiveSchedules, activeSchedules) {
+		runner.Status.ActiveSchedules = activeSchedules
+		if err := r.updateStatus(ctx, runner); err != nil {
+			if strings.Contains(err.Error(), optimisticLockErrorMsg) {
+				return ctrl.Result{RequeueAfter: time.Second}, nil
+			}
+			return ctrl.Result{}, err
+		}
+		logger.V(1).Info("update", "status", runner.Status)
+	}
+
 	if err := r.cleanupOwnedResources(ctx, runner); err != nil {
 		return ctrl.Result{}, err
 	}
 
+	if runner.Spec.Build.RefreshInterval != nil && runner.Spec.Build.RefreshInterval.Duration > 0 {
+		refreshInterval := runner.Spec.Build.RefreshInterval.Duration
+		if runner.Status.BaseImage != runner.Spec.Image || runner.Status.BaseImageRefreshedAt == nil || time.Since(runner.Status.BaseImageRefreshedAt.Time) >= refreshInterval {
+			digest, err := r.resolveImageDigest(ctx, runner, runner.Spec.Image)
+			if err != nil {
+				r.Recorder.Eventf(runner, coreV1.EventTypeWarning, "FailedRefresh", "Failed to resolve digest of base image %q: %v", runner.Spec.Image, err)
+				logger.Error(err, "failed to resolve digest of base image")
+			} else if runner.Status.BaseImage != runner.Spec.Image || runner.Status.BaseImageDigest != digest {
+				if runner.Status.BaseImage == runner.Spec.Image {
+					r.Recorder.Eventf(runner, coreV1.EventTypeNormal, "BaseImageUpdated", "Base image %q moved to %s", runner.Spec.Image, digest)
+				}
+				runner.Status.BaseImage = runner.Spec.Image
+				runner.Status.BaseImageDigest = digest
+			}
+			runner.Status.BaseImageRefreshedAt = &metaV1.Time{Time: time.Now()}
+
+			if err := r.updateStatus(ctx, runner); err != nil {
+				if strings.Contains(err.Error(), optimisticLockErrorMsg) {
+					return ctrl.Result{RequeueAfter: time.Second}, nil
+				}
+				return ctrl.Result{}, err
+			}
+			logger.V(1).Info("update", "status", runner.Status)
+		}
+		requeueAfter = shorterRequeueAfter(requeueAfter, time.Until(runner.Status.BaseImageRefreshedAt.Add(refreshInterval)))
+	}
+
+	runnerVersion := r.runnerVersion(runner)
+	if runner.Spec.RunnerVersion == latestRunnerVersion {
+		version, err := r.runnerVersionCache.get(ctx, r.RunnerVersionRefreshInterval)
+		if err != nil {
+			r.Recorder.Eventf(runner, coreV1.EventTypeWarning, "FailedRefresh", "Failed to resolve latest runner version: %v", err)
+			logger.Error(err, "failed to resolve latest runner version")
+		} else {
+			runnerVersion = version
+		}
+		if r.RunnerVersionRefreshInterval > 0 {
+			requeueAfter = shorterRequeueAfter(requeueAfter, r.RunnerVersionRefreshInterval)
+		}
+	}
+	if runner.Status.RunnerVersion != runnerVersion {
+		if runner.Status.RunnerVersion != "" {
+			r.Recorder.Eventf(runner, coreV1.EventTypeNormal, "RunnerVersionUpdated", "Runner version changed from %s to %s", runner.Status.RunnerVersion, runnerVersion)
+		}
+		runner.Status.RunnerVersion = runnerVersion
+
+		if err := r.updateStatus(ctx, runner); err != nil {
+			if strings.Contains(err.Error(), optimisticLockErrorMsg) {
+				return ctrl.Result{RequeueAfter: time.Second}, nil
+			}
+			return ctrl.Result{}, err
+		}
+		logger.V(1).Info("update", "status", runner.Status)
+	}
+
 	if runner.Spec.TokenSecretKeyRef == nil && r.GitHubAppClientId != "" && r.GitHubAppInstallationId != "" && r.GitHubAppPrivateKey != "" {
 		var tokenSecret v1.Secret
 		if err := r.Client.Get(
@@ -102,7 +250,7 @@ func (r *RunnerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 			if err != nil {
 				return ctrl.Result{}, err
 			}
-			requeueAfter = expire.Sub(time.Now()) - time.Minute
+			requeueAfter = shorterRequeueAfter(requeueAfter, expire.Sub(time.Now())-time.Minute)
 		} else if err != nil {
 			return ctrl.Result{}, err
 		} else {
@@ -126,7 +274,7 @@ func (r *RunnerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 				if err != nil {
 					return ctrl.Result{}, err
 				}
-				requeueAfter = expire.Sub(time.Now()) - time.Minute
+				requeueAfter = shorterRequeueAfter(requeueAfter, expire.Sub(time.Now())-time.Minute)
 			}
 		}
 
@@ -173,8 +321,32 @@ func (r *RunnerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 		}
 	}
 
+	if err := r.reconcileToolCache(ctx, runner); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.reconcileToolCachePruning(ctx, runner); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// GitHub runners of the repository are listed once for all steps using them below
+	lister := &repositoryRunnerLister{reconciler: r, runner: runner}
+
 	var deployment appsV1.Deployment
-	if err := r.Client.Get(
+	if usesStatefulSet(runner) {
+		if err := r.reconcileStatefulSet(ctx, runner); err != nil {
+			if strings.Contains(err.Error(), optimisticLockErrorMsg) {
+				return ctrl.Result{RequeueAfter: time.Second}, nil
+			}
+			return ctrl.Result{}, err
+		}
+	} else if len(runner.Spec.Pools) > 0 {
+		if err := r.reconcilePools(ctx, runner); err != nil {
+			if strings.Contains(err.Error(), optimisticLockErrorMsg) {
+				return ctrl.Result{RequeueAfter: time.Second}, nil
+			}
+			return ctrl.Result{}, err
+		}
+	} else if err := r.Client.Get(
 		ctx,
 		client.ObjectKey{
 			Name:      req.Name + "-runner",
@@ -194,65 +366,279 @@ func (r *RunnerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 	} else if err != nil {
 		return ctrl.Result{}, err
 	} else {
+		if runner.Spec.Replicas != nil && !reflect.DeepEqual(deployment.Spec.Replicas, runner.Spec.Replicas) {
+			deployment.Spec.Replicas = runner.Spec.Replicas
+			if err := r.Update(ctx, &deployment); err != nil {
+				if strings.Contains(err.Error(), optimisticLockErrorMsg) {
+					return ctrl.Result{RequeueAfter: time.Second}, nil
+				}
+				return ctrl.Result{}, err
+			}
+			r.Recorder.Eventf(runner, coreV1.EventTypeNormal, "SuccessfulRescale", "Scaled deployment %q to %d", deployment.Name, *deployment.Spec.Replicas)
+		}
+
 		expectedDeployment := r.buildDeployment(runner)
-		if !reflect.DeepEqual(deployment.Spec.Template, expectedDeployment.Spec.Template) {
-			deployment.Spec.Template = expectedDeployment.Spec.Template
+		if reflect.DeepEqual(deployment.Spec.Template, expectedDeployment.Spec.Template) {
+			pollAfter, err := r.finishCanary(ctx, runner, lister)
+			if err != nil {
+				if strings.Contains(err.Error(), optimisticLockErrorMsg) {
+					return ctrl.Result{RequeueAfter: time.Second}, nil
+				}
+				return ctrl.Result{}, err
+			}
+			if pollAfter > 0 {
+				requeueAfter = shorterRequeueAfter(requeueAfter, pollAfter)
+			}
+		} else {
+			promote := true
+			if runner.Spec.Rollout.Canary != nil {
+				var pollAfter time.Duration
+				promote, pollAfter, err = r.rolloutCanary(ctx, runner, expectedDeployment, lister)
+				if err != nil {
+					if strings.Contains(err.Error(), optimisticLockErrorMsg) {
+						return ctrl.Result{RequeueAfter: time.Second}, nil
+					}
+					return ctrl.Result{}, err
+				}
+				if pollAfter > 0 {
+					requeueAfter = shorterRequeueAfter(requeueAfter, pollAfter)
+				}
+			}
 
-			if err := r.Update(ctx, &deployment); err != nil {
+			if promote {
+				deployment.Spec.Template = expectedDeployment.Spec.Template
+
+				if err := r.Update(ctx, &deployment); err != nil {
+					if strings.Contains(err.Error(), optimisticLockErrorMsg) {
+						return ctrl.Result{RequeueAfter: time.Second}, nil
+					}
+					return ctrl.Result{}, err
+				}
+				r.Recorder.Eventf(runner, coreV1.EventTypeNormal, "SuccessfulUpdated", "Updated deployment: %q", deployment.Name)
+				logger.V(1).Info("update", "deployment", deployment)
+			}
+		}
+	}
+
+	pollAfter, err := r.scalePoolsByQueue(ctx, runner, lister)
+	if err != nil {
+		if strings.Contains(err.Error(), optimisticLockErrorMsg) {
+			return ctrl.Result{RequeueAfter: time.Second}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	if pollAfter > 0 {
+		requeueAfter = shorterRequeueAfter(requeueAfter, pollAfter)
+	}
+
+	if err := r.recordRunnerCounts(ctx, runner); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if r.PodDeletionCostInterval > 0 {
+		if err := r.syncRepositoryRunners(ctx, runner, lister); err != nil {
+			logger.Error(err, "failed to sync repository runners")
+		}
+		requeueAfter = shorterRequeueAfter(requeueAfter, r.PodDeletionCostInterval)
+	}
+
+	if builder := r.builder(runner); builder != nil {
+		changed, err := r.updateBuildCondition(ctx, runner)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if runner.Spec.Build.Signing != nil && meta.IsStatusConditionTrue(runner.Status.Conditions, conditionImageBuilt) {
+			signed, err := r.reconcileSigning(ctx, runner)
+			if err != nil {
+				logger.Error(err, "failed to sign runner image")
+			}
+			if signed {
+				// Runner pods are pinned to the signed digest at the next reconcile
+				requeueAfter = shorterRequeueAfter(requeueAfter, time.Second)
+				changed = true
+			}
+		}
+		if changed {
+			if err := r.updateStatus(ctx, runner); err != nil {
 				if strings.Contains(err.Error(), optimisticLockErrorMsg) {
 					return ctrl.Result{RequeueAfter: time.Second}, nil
 				}
 				return ctrl.Result{}, err
 			}
-			r.Recorder.Eventf(runner, coreV1.EventTypeNormal, "SuccessfulUpdated", "Updated deployment: %q", deployment.Name)
-			logger.V(1).Info("update", "deployment", deployment)
+			logger.V(1).Info("update", "status", runner.Status)
 		}
 	}
 
+	if builder := r.builder(runner); builder != nil && runner.Spec.Build.TimeoutSeconds != nil {
+		timeout := time.Duration(*runner.Spec.Build.TimeoutSeconds) * time.Second
+		remaining, changed, err := r.deleteTimedOutBuilds(ctx, runner, builder.name(), timeout)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if changed {
+			if err := r.updateStatus(ctx, runner); err != nil {
+				if strings.Contains(err.Error(), optimisticLockErrorMsg) {
+					return ctrl.Result{RequeueAfter: time.Second}, nil
+				}
+				return ctrl.Result{}, err
+			}
+			logger.V(1).Info("update", "status", runner.Status)
+		}
+		// Running builds do not change their pods, so their timeouts are checked by polling
+		if remaining == 0 {
+			remaining = timeout
+		}
+		requeueAfter = shorterRequeueAfter(requeueAfter, remaining)
+	}
+
 	return ctrl.Result{RequeueAfter: requeueAfter}, nil
 }
 
+// updateStatus updates the status of the runner, keeping its spec which may be modified in memory, such as by the runner class.
+func (r *RunnerReconciler) updateStatus(ctx context.Context, runner *garV1.Runner) error {
+	updated := runner.DeepCopy()
+	if err := r.Status().Update(ctx, updated); err != nil {
+		return err
+	}
+	runner.ObjectMeta = updated.ObjectMeta
+	runner.Status = updated.Status
+	return nil
+}
+
+func shorterRequeueAfter(current time.Duration, candidate time.Duration) time.Duration {
+	if candidate <= 0 {
+		candidate = time.Second
+	}
+	if current == 0 || candidate < current {
+		return candidate
+	}
+	return current
+}
+
+// baseImageDigest returns the digest of the base image pinned by the last refresh, if any.
+func baseImageDigest(runner *garV1.Runner) string {
+	if runner.Spec.Build.RefreshInterval == nil || runner.Status.BaseImage != runner.Spec.Image {
+		return ""
+	}
+	return runner.Status.BaseImageDigest
+}
+
+func baseImage(runner *garV1.Runner) string {
+	digest := baseImageDigest(runner)
+	if digest == "" {
+		return runner.Spec.Image
+	}
+	named, err := dockerref.ParseNormalizedNamed(runner.Spec.Image)
+	if err != nil {
+		return runner.Spec.Image
+	}
+	return dockerref.TrimNamed(named).String() + "@" + digest
+}
+
+func architecture(runner *garV1.Runner) string {
+	if runner.Spec.Architecture == "" {
+		return defaultArchitecture
+	}
+	return runner.Spec.Architecture
+}
+
+// imageArchitecture returns the architecture distinguishing names of runner images,
+// which is empty for the default architecture so that images built before architectures were supported are kept.
+func imageArchitecture(runner *garV1.Runner) string {
+	if architecture(runner) == defaultArchitecture {
+		return ""
+	}
+	return architecture(runner)
+}
+
+// terminationGracePeriodSeconds returns how long runner pods wait in the preStop hook for the current job to finish.
+func terminationGracePeriodSeconds(runner *garV1.Runner) int64 {
+	if runner.Spec.TerminationGracePeriodSeconds != nil {
+		return *runner.Spec.TerminationGracePeriodSeconds
+	}
+	return 30
+}
+
 func (r *RunnerReconciler) buildRepositoryName(runner *garV1.Runner) string {
 	named, err := dockerref.ParseNormalizedNamed(runner.Spec.Image)
 	if err != nil {
-		return fmt.Sprintf("%x", sha256.Sum256([]byte(runner.Spec.Image+r.BinaryVersion+r.RunnerVersion)))[:7]
+		return fmt.Sprintf("%x", sha256.Sum256([]byte(runner.Spec.Image+r.BinaryVersion+r.runnerVersion(runner)+imageArchitecture(runner)+baseImageDigest(runner))))[:7]
 	}
 	trimmed := dockerref.TrimNamed(named).String()
-	return fmt.Sprintf("%x", sha256.Sum256([]byte(trimmed+r.BinaryVersion+r.RunnerVersion)))[:7]
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(trimmed+r.BinaryVersion+r.runnerVersion(runner)+imageArchitecture(runner)+baseImageDigest(runner))))[:7]
+}
+
+func (r *RunnerReconciler) buildRegistry(runner *garV1.Runner) garV1.Registry {
+	registry := runner.Spec.Build.Registry
+	if registry.DockerConfigSecretRef == nil && r.RegistryDockerConfigSecretName != "" {
+		registry.DockerConfigSecretRef = &v1.LocalObjectReference{
+			Name: r.RegistryDockerConfigSecretName,
+		}
+	}
+	if registry.Insecure == nil {
+		insecure := r.RegistryInsecure
+		registry.Insecure = &insecure
+	}
+	if registry.CACertificateConfigMapKeyRef == nil && r.RegistryCACertificateConfigMapName != "" {
+		registry.CACertificateConfigMapKeyRef = &v1.ConfigMapKeySelector{
+			LocalObjectReference: v1.LocalObjectReference{
+				Name: r.RegistryCACertificateConfigMapName,
+			},
+			Key: "ca.crt",
+		}
+	}
+	return registry
+}
+
+// registryInsecure reports whether the registry returned by buildRegistry is accessed over plain HTTP or without verifying its certificate.
+func registryInsecure(registry garV1.Registry) bool {
+	return registry.Insecure != nil && *registry.Insecure
 }
 
-func (r *RunnerReconciler) buildBuilderContainer(runner *garV1.Runner) v1.Container {
+func (r *RunnerReconciler) buildBuilderContainer(runner *garV1.Runner, builder imageBuilder) v1.Container {
+	if preset, ok := builderResourcePresets[runner.Spec.Build.ResourcePreset]; ok {
+		if runner.Spec.BuilderContainerSpec.Resources.Requests == nil {
+			runner.Spec.BuilderContainerSpec.Resources.Requests = make(v1.ResourceList)
+		}
+		for name, quantity := range preset.Requests {
+			if _, ok := runner.Spec.BuilderContainerSpec.Resources.Requests[name]; !ok {
+				runner.Spec.BuilderContainerSpec.Resources.Requests[name] = quantity
+			}
+		}
+		if runner.Spec.BuilderContainerSpec.Resources.Limits == nil {
+			runner.Spec.BuilderContainerSpec.Resources.Limits = make(v1.ResourceList)
+		}
+		for name, quantity := range preset.Limits {
+			if _, ok := runner.Spec.BuilderContainerSpec.Resources.Limits[name]; !ok {
+				runner.Spec.BuilderContainerSpec.Resources.Limits[name] = quantity
+			}
+		}
+	}
 	if runner.Spec.BuilderContainerSpec.Resources.Limits == nil {
 		runner.Spec.BuilderContainerSpec.Resources.Limits = make(v1.ResourceList)
 	}
 	if runner.Spec.BuilderContainerSpec.Resources.Limits.Memory().IsZero() {
 		runner.Spec.BuilderContainerSpec.Resources.Limits[v1.ResourceMemory] = resource.MustParse("4Gi")
 	}
-	return v1.Container{
-		Name:            "kaniko",
-		Image:           r.KanikoImage,
-		ImagePullPolicy: v1.PullIfNotPresent,
-		Args: []string{
-			"--dockerfile=Dockerfile",
-			"--context=dir:///workspace",
-			"--cache=true",
-			"--compressed-caching=false",
-			fmt.Sprintf("--destination=%s/%s", r.PushRegistryHost, r.buildRepositoryName(runner)),
-		},
-		EnvFrom: runner.Spec.BuilderContainerSpec.EnvFrom,
-		Env:     runner.Spec.BuilderContainerSpec.Env,
-		VolumeMounts: append([]v1.VolumeMount{
-			{
-				Name:      "workspace",
-				MountPath: "/workspace/Dockerfile",
-				SubPath:   "Dockerfile",
-				ReadOnly:  true,
-			},
-		}, runner.Spec.BuilderContainerSpec.VolumeMounts...),
-		Resources:                runner.Spec.BuilderContainerSpec.Resources,
-		TerminationMessagePath:   coreV1.TerminationMessagePathDefault,
-		TerminationMessagePolicy: coreV1.TerminationMessageReadFile,
+	c := builder.container(runner, r.buildPushImage(runner), r.buildRegistry(runner))
+	c.EnvFrom = append(c.EnvFrom, runner.Spec.BuilderContainerSpec.EnvFrom...)
+	c.Env = append(c.Env, runner.Spec.BuilderContainerSpec.Env...)
+	c.VolumeMounts = append(c.VolumeMounts, runner.Spec.BuilderContainerSpec.VolumeMounts...)
+	c.Resources = runner.Spec.BuilderContainerSpec.Resources
+	c.TerminationMessagePath = coreV1.TerminationMessagePathDefault
+	c.TerminationMessagePolicy = coreV1.TerminationMessageReadFile
+	return c
+}
+
+func (r *RunnerReconciler) buildPushImage(runner *garV1.Runner) string {
+	return fmt.Sprintf("%s/%s", r.PushRegistryHost, r.buildRepositoryName(runner))
+}
+
+func (r *RunnerReconciler) buildPullImage(runner *garV1.Runner) string {
+	if r.builder(runner) == nil {
+		return runner.Spec.Image
 	}
+	return fmt.Sprintf("%s/%s", r.PullRegistryHost, r.buildRepositoryName(runner))
 }
 
 func (r *RunnerReconciler) buildRunnerContainer(runner *garV1.Runner) v1.Container {
@@ -312,7 +698,7 @@ func (r *RunnerReconciler) buildRunnerContainer(runner *garV1.Runner) v1.Contain
 				Type: coreV1.SeccompProfileTypeRuntimeDefault,
 			},
 		},
-		Image:                    fmt.Sprintf("%s/%s", r.PullRegistryHost, r.buildRepositoryName(runner)),
+		Image:                    r.buildRunnerImage(runner),
 		ImagePullPolicy:          v1.PullAlways,
 		Args:                     args,
 		EnvFrom:                  envFrom,
@@ -322,45 +708,65 @@ func (r *RunnerReconciler) buildRunnerContainer(runner *garV1.Runner) v1.Contain
 		TerminationMessagePath:   coreV1.TerminationMessagePathDefault,
 		TerminationMessagePolicy: coreV1.TerminationMessageReadFile,
 	}
-	if r.Disableupdate {
+	c.Args = append(c.Args, "--labels="+ownerLabel(runner))
+	if r.disableUpdate(runner) {
 		c.Args = append(c.Args, "--disableupdate")
 	}
-	return c
-}
-
-func (r *RunnerReconciler) buildExporterContainer(runner *garV1.Runner) v1.Container {
-	return v1.Container{
-		Name:            "exporter",
-		Image:           r.ExporterImage,
-		ImagePullPolicy: v1.PullAlways,
-		Args: []string{
-			"server",
-			"--api-address=0.0.0.0:8000",
-			"--monitor-address=0.0.0.0:9090",
-			"--repository=$(REPOSITORY)",
-			"--token=$(TOKEN)",
-		},
-		Env: []coreV1.EnvVar{
-			{
-				Name:  "REPOSITORY",
-				Value: runner.Spec.Repository,
+	if usesStatefulSet(runner) {
+		// Pods of a StatefulSet come back with the same name, which is still registered if they were not removed
+		c.Args = append(c.Args, "--replace")
+	}
+	c.Args = append(c.Args, buildHookArgs(runner)...)
+	c.VolumeMounts = append(c.VolumeMounts, buildHookVolumeMounts(runner)...)
+	applyCache(runner, &c)
+	// Waits for the current job before the runner receives SIGTERM and unregisters itself
+	c.Lifecycle = &v1.Lifecycle{
+		PreStop: &v1.LifecycleHandler{
+			Exec: &v1.ExecAction{
+				Command: []string{"/usr/local/bin/runner", "--drain"},
 			},
-			{
-				Name: "TOKEN",
-				ValueFrom: &coreV1.EnvVarSource{
-					SecretKeyRef: runner.Spec.TokenSecretKeyRef,
-				},
+		},
+	}
+	c.Ports = append(c.Ports, v1.ContainerPort{
+		Name:          "health",
+		ContainerPort: 8081,
+		Protocol:      "TCP",
+	})
+	if r.EnableRunnerMetrics {
+		c.Args = append(c.Args, "--metrics-address=0.0.0.0:9090")
+		c.Ports = append(c.Ports, v1.ContainerPort{
+			Name:          "metrics",
+			ContainerPort: 9090,
+			Protocol:      "TCP",
+		})
+	}
+	c.ReadinessProbe = &v1.Probe{
+		ProbeHandler: v1.ProbeHandler{
+			HTTPGet: &v1.HTTPGetAction{
+				Path:   "/readyz",
+				Port:   intstr.FromString("health"),
+				Scheme: v1.URISchemeHTTP,
 			},
 		},
-		Ports: []coreV1.ContainerPort{
-			{
-				ContainerPort: 9090,
-				Protocol:      "TCP",
+		TimeoutSeconds:   1,
+		PeriodSeconds:    10,
+		SuccessThreshold: 1,
+		FailureThreshold: 3,
+	}
+	c.LivenessProbe = &v1.Probe{
+		ProbeHandler: v1.ProbeHandler{
+			HTTPGet: &v1.HTTPGetAction{
+				Path:   "/healthz",
+				Port:   intstr.FromString("health"),
+				Scheme: v1.URISchemeHTTP,
 			},
 		},
-		TerminationMessagePath:   coreV1.TerminationMessagePathDefault,
-		TerminationMessagePolicy: coreV1.TerminationMessageReadFile,
+		TimeoutSeconds:   1,
+		PeriodSeconds:    10,
+		SuccessThreshold: 1,
+		FailureThreshold: 3,
 	}
+	return c
 }
 
 func (r *RunnerReconciler) buildDeployment(runner *garV1.Runner) *appsV1.Deployment {
@@ -368,8 +774,29 @@ func (r *RunnerReconciler) buildDeployment(runner *garV1.Runner) *appsV1.Deploym
 		r.buildRunnerContainer(runner),
 	}
 
-	if r.EnableRunnerMetrics {
-		containers = append(containers, r.buildExporterContainer(runner))
+	volumes := []v1.Volume{
+		{
+			Name: "workspace",
+			VolumeSource: v1.VolumeSource{
+				ConfigMap: &v1.ConfigMapVolumeSource{
+					LocalObjectReference: v1.LocalObjectReference{
+						Name: runner.Name + "-workspace",
+					},
+					DefaultMode: func(i int32) *int32 {
+						return &i
+					}(420),
+				},
+			},
+		},
+	}
+	volumes = append(volumes, buildHookVolumes(runner)...)
+	volumes = append(volumes, buildCacheVolumes(runner)...)
+	var imagePullSecrets []v1.LocalObjectReference
+
+	registry := r.buildRegistry(runner)
+	volumes = append(volumes, buildRegistryVolumes(registry)...)
+	if registry.DockerConfigSecretRef != nil {
+		imagePullSecrets = append(imagePullSecrets, *registry.DockerConfigSecretRef)
 	}
 
 	appLabel := runner.Name + "-runner"
@@ -380,13 +807,29 @@ func (r *RunnerReconciler) buildDeployment(runner *garV1.Runner) *appsV1.Deploym
 		labels[k] = v
 	}
 	runner.Spec.Template.ObjectMeta.Labels = labels
+	var initContainers []v1.Container
 	annotations := map[string]string{
 		"image": runner.Spec.Image,
 	}
+	if builder := r.builder(runner); builder != nil {
+		initContainers = append(initContainers, r.buildBuilderContainer(runner, builder))
+		for k, v := range builder.annotations() {
+			annotations[k] = v
+		}
+	}
 	for k, v := range runner.Spec.Template.ObjectMeta.Annotations {
 		annotations[k] = v
 	}
 	runner.Spec.Template.ObjectMeta.Annotations = annotations
+	securityContext := &coreV1.PodSecurityContext{
+		SeccompProfile: &coreV1.SeccompProfile{
+			Type: coreV1.SeccompProfileTypeRuntimeDefault,
+		},
+	}
+	if runner.Spec.Cache.ToolCache != nil || runner.Spec.Cache.Work != nil {
+		// Makes cache volumes writable by the runner user
+		securityContext.FSGroup = func(i int64) *int64 { return &i }(runnerGroupID)
+	}
 	return &appsV1.Deployment{
 		ObjectMeta: metaV1.ObjectMeta{
 			Name:      runner.Name + "-runner",
@@ -399,6 +842,9 @@ func (r *RunnerReconciler) buildDeployment(runner *garV1.Runner) *appsV1.Deploym
 				},
 			},
 			Replicas: func(i int32) *int32 {
+				if runner.Spec.Replicas != nil {
+					return runner.Spec.Replicas
+				}
 				return &i
 			}(1),
 			Strategy: appsV1.DeploymentStrategy{
@@ -418,6 +864,21 @@ func (r *RunnerReconciler) buildDeployment(runner *garV1.Runner) *appsV1.Deploym
 				ObjectMeta: runner.Spec.Template.ObjectMeta,
 				Spec: v1.PodSpec{
 					Affinity: &v1.Affinity{
+						NodeAffinity: &v1.NodeAffinity{
+							RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{
+								NodeSelectorTerms: []v1.NodeSelectorTerm{
+									{
+										MatchExpressions: []v1.NodeSelectorRequirement{
+											{
+												Key:      "kubernetes.io/arch",
+												Operator: v1.NodeSelectorOpIn,
+												Values:   []string{architecture(runner)},
+											},
+										},
+									},
+								},
+							},
+						},
 						PodAntiAffinity: &v1.PodAntiAffinity{
 							PreferredDuringSchedulingIgnoredDuringExecution: []v1.WeightedPodAffinityTerm{
 								{
@@ -434,51 +895,76 @@ func (r *RunnerReconciler) buildDeployment(runner *garV1.Runner) *appsV1.Deploym
 							},
 						},
 					},
-					InitContainers: []v1.Container{
-						r.buildBuilderContainer(runner),
-					},
-					Containers: containers,
-					Volumes: append([]v1.Volume{
+					InitContainers:   initContainers,
+					Containers:       containers,
+					Volumes:          append(volumes, runner.Spec.Template.Spec.Volumes...),
+					ImagePullSecrets: imagePullSecrets,
+					RestartPolicy:    coreV1.RestartPolicyAlways,
+					TerminationGracePeriodSeconds: func(i int64) *int64 {
+						return &i
+					}(terminationGracePeriodSeconds(runner)),
+					DNSPolicy:       coreV1.DNSClusterFirst,
+					SecurityContext: securityContext,
+					SchedulerName:   coreV1.DefaultSchedulerName,
+				},
+			},
+		},
+	}
+}
+
+// buildRegistryVolumes returns volumes of the docker config and the CA certificate of the registry.
+func buildRegistryVolumes(registry garV1.Registry) []v1.Volume {
+	var volumes []v1.Volume
+	if registry.DockerConfigSecretRef != nil {
+		volumes = append(volumes, v1.Volume{
+			Name: "registry-docker-config",
+			VolumeSource: v1.VolumeSource{
+				Secret: &v1.SecretVolumeSource{
+					SecretName: registry.DockerConfigSecretRef.Name,
+					Items: []v1.KeyToPath{
 						{
-							Name: "workspace",
-							VolumeSource: v1.VolumeSource{
-								ConfigMap: &v1.ConfigMapVolumeSource{
-									LocalObjectReference: v1.LocalObjectReference{
-										Name: runner.Name + "-workspace",
-									},
-									DefaultMode: func(i int32) *int32 {
-										return &i
-									}(420),
-								},
-							},
+							Key:  v1.DockerConfigJsonKey,
+							Path: "config.json",
 						},
-					}, runner.Spec.Template.Spec.Volumes...),
-					RestartPolicy: coreV1.RestartPolicyAlways,
-					TerminationGracePeriodSeconds: func(i int64) *int64 {
+					},
+					DefaultMode: func(i int32) *int32 {
 						return &i
-					}(30),
-					DNSPolicy: coreV1.DNSClusterFirst,
-					SecurityContext: &coreV1.PodSecurityContext{
-						SeccompProfile: &coreV1.SeccompProfile{
-							Type: coreV1.SeccompProfileTypeRuntimeDefault,
+					}(420),
+				},
+			},
+		})
+	}
+	if registry.CACertificateConfigMapKeyRef != nil {
+		volumes = append(volumes, v1.Volume{
+			Name: "registry-ca-certificate",
+			VolumeSource: v1.VolumeSource{
+				ConfigMap: &v1.ConfigMapVolumeSource{
+					LocalObjectReference: registry.CACertificateConfigMapKeyRef.LocalObjectReference,
+					Items: []v1.KeyToPath{
+						{
+							Key:  registry.CACertificateConfigMapKeyRef.Key,
+							Path: "ca.crt",
 						},
 					},
-					SchedulerName: coreV1.DefaultSchedulerName,
+					DefaultMode: func(i int32) *int32 {
+						return &i
+					}(420),
 				},
 			},
-		},
+		})
 	}
+	return volumes
 }
 
 func (r *RunnerReconciler) buildWorkspaceConfigMap(runner *garV1.Runner) *v1.ConfigMap {
-	return &v1.ConfigMap{
+	configMap := &v1.ConfigMap{
 		ObjectMeta: metaV1.ObjectMeta{
 			Name:      runner.Name + "-workspace",
 			Namespace: runner.Namespace,
 		},
 		Data: map[string]string{
 			"Dockerfile": fmt.Sprintf(`
-FROM %s
+FROM %[1]s
 USER root
 ENV DEBIAN_FRONTEND=noninteractive
 RUN (command -v apt && apt update && apt install -y ca-certificates iputils-ping tar sudo git) || \
@@ -488,7 +974,9 @@ RUN (command -v apt && apt update && apt install -y ca-certificates iputils-ping
       (command -v zypper && zypper install -n ca-certificates iputils tar sudo git-core) || \
       (echo "Unknown OS version" && exit 1)
 
-ADD https://github.com/kaidotdev/github-actions-runner-controller/releases/download/v%s/runner_%s_linux_amd64 /usr/local/bin/runner
+ADD %[2]s/v%[3]s/runner_%[3]s_linux_%[4]s /usr/local/bin/runner
+ADD %[2]s/v%[3]s/github-actions-runner-controller_%[3]s_checksums.txt /tmp/runner_checksums.txt
+RUN echo "$(grep ' runner_%[3]s_linux_%[4]s$' /tmp/runner_checksums.txt | cut -d ' ' -f 1)  /usr/local/bin/runner" | sha256sum -c - && rm /tmp/runner_checksums.txt
 RUN chmod +x /usr/local/bin/runner
 
 RUN echo 'runner::60000:60000::/home/runner:/bin/sh' >> /etc/passwd
@@ -500,19 +988,63 @@ RUN echo "runner ALL=(ALL) NOPASSWD: ALL" | sudo EDITOR='tee -a' visudo
 
 WORKDIR /home/runner
 
-RUN /usr/local/bin/runner --only-install --runner-version %s
+RUN /usr/local/bin/runner --only-install --runner-version %[5]s --runner-download-url %[6]s
 
 USER 60000
 
 ENTRYPOINT ["/usr/local/bin/runner"]
-`, runner.Spec.Image, r.BinaryVersion, r.BinaryVersion, r.RunnerVersion),
+`, baseImage(runner), strings.TrimSuffix(r.BinaryDownloadURL, "/"), r.BinaryVersion, architecture(runner), r.runnerVersion(runner), r.RunnerDownloadURL),
 		},
 	}
+	if builder := r.builder(runner); builder != nil {
+		for k, v := range builder.workspace(runner, r.buildPushImage(runner), r.buildRegistry(runner)) {
+			configMap.Data[k] = v
+		}
+	}
+	return configMap
 }
 
 func (r *RunnerReconciler) createTokenSecret(runner *garV1.Runner) (*v1.Secret, error) {
+	start := time.Now()
+	secret, err := r.mintTokenSecret(runner)
+	observeTokenMint(start, err)
+	return secret, err
+}
+
+func (r *RunnerReconciler) mintTokenSecret(runner *garV1.Runner) (*v1.Secret, error) {
+	token, expiresAt, err := mintInstallationToken(
+		r.GitHubAppPrivateKey,
+		r.GitHubAppClientId,
+		r.GitHubAppInstallationId,
+		[]string{strings.SplitN(runner.Spec.Repository, "/", 2)[1]},
+		map[string]string{
+			"actions":        "read",
+			"administration": "write",
+			"metadata":       "read",
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.Secret{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      runner.Name,
+			Namespace: runner.Namespace,
+			Annotations: map[string]string{
+				expiresAtAnnotation: expiresAt,
+			},
+		},
+		StringData: map[string]string{
+			"GITHUB_TOKEN": token,
+		},
+	}, nil
+}
+
+// mintInstallationToken mints an installation access token of the GitHub App, which is scoped to all repositories of the installation if repositories is empty.
+func mintInstallationToken(privateKey string, clientId string, installationId string, repositories []string, permissions map[string]string) (string, string, error) {
 	body := struct {
-		Repositories  []string          `json:"repositories"`
+		Repositories  []string          `json:"repositories,omitempty"`
 		RepositoryIds []int             `json:"repository_ids"`
 		Permissions   map[string]string `json:"permissions"`
 	}{}
@@ -522,58 +1054,42 @@ func (r *RunnerReconciler) createTokenSecret(runner *garV1.Runner) (*v1.Secret,
 		ExpiresAt string `json:"expires_at"`
 	}{}
 
-	err, jwtToken := signJwt(r.GitHubAppPrivateKey, r.GitHubAppClientId)
+	err, jwtToken := signJwt(privateKey, clientId)
 	if err != nil {
-		return nil, xerrors.Errorf("failed to sign jwt: %w", err)
+		return "", "", xerrors.Errorf("failed to sign jwt: %w", err)
 	}
 
-	body.Repositories = []string{strings.SplitN(runner.Spec.Repository, "/", 2)[1]}
-	body.Permissions = map[string]string{
-		"actions":        "read",
-		"administration": "write",
-		"metadata":       "read",
-	}
+	body.Repositories = repositories
+	body.Permissions = permissions
 	b, err := json.Marshal(body)
 	if err != nil {
-		return nil, xerrors.Errorf("failed to marshal body: %w", err)
+		return "", "", xerrors.Errorf("failed to marshal body: %w", err)
 	}
 
-	accessTokenRequest, err := http.NewRequest("POST", fmt.Sprintf("https://api.github.com/app/installations/%s/access_tokens", r.GitHubAppInstallationId), bytes.NewReader(b))
+	accessTokenRequest, err := http.NewRequest("POST", fmt.Sprintf("https://api.github.com/app/installations/%s/access_tokens", installationId), bytes.NewReader(b))
 	if err != nil {
-		return nil, xerrors.Errorf("failed to create request: %w", err)
+		return "", "", xerrors.Errorf("failed to create request: %w", err)
 	}
 
 	accessTokenRequest.Header.Set("Accept", "application/vnd.github+json")
 	accessTokenRequest.Header.Set("Authorization", fmt.Sprintf("Bearer %s", *jwtToken))
 	accessTokenRequest.Header.Set("X-GitHub-Api-Version", "2022-11-28")
-	accessTokenResponse, err := http.DefaultClient.Do(accessTokenRequest)
+	accessTokenResponse, err := doGitHubRequest(accessTokenRequest, "access_tokens")
 	if err != nil {
-		return nil, xerrors.Errorf("failed to do request: %w", err)
+		return "", "", xerrors.Errorf("failed to do request: %w", err)
 	}
 	defer func() {
 		_ = accessTokenResponse.Body.Close()
 	}()
 
 	if accessTokenResponse.StatusCode != http.StatusCreated {
-		return nil, xerrors.Errorf("failed to get access token: %d", accessTokenResponse.StatusCode)
+		return "", "", xerrors.Errorf("failed to get access token: %d", accessTokenResponse.StatusCode)
 	}
 
 	if err := json.NewDecoder(accessTokenResponse.Body).Decode(&accessToken); err != nil {
-		return nil, xerrors.Errorf("failed to decode access token: %w", err)
+		return "", "", xerrors.Errorf("failed to decode access token: %w", err)
 	}
-
-	return &v1.Secret{
-		ObjectMeta: metaV1.ObjectMeta{
-			Name:      runner.Name,
-			Namespace: runner.Namespace,
-			Annotations: map[string]string{
-				expiresAtAnnotation: accessToken.ExpiresAt,
-			},
-		},
-		StringData: map[string]string{
-			"GITHUB_TOKEN": accessToken.Token,
-		},
-	}, nil
+	return accessToken.Token, accessToken.ExpiresAt, nil
 }
 
 func signJwt(privateKey string, clientId string) (error, *string) {
@@ -602,6 +1118,89 @@ func signJwt(privateKey string, clientId string) (error, *string) {
 	return nil, &jwtToken
 }
 
+// deleteTimedOutBuilds deletes runner pods whose builder container has been running longer than timeout, so that the build is retried,
+// and returns the time until the next running build times out, and whether the status changed.
+// As a deleted pod is recreated right away, retries of builds which keep timing out are backed off exponentially by leaving the timed out pod in place.
+func (r *RunnerReconciler) deleteTimedOutBuilds(ctx context.Context, runner *garV1.Runner, containerName string, timeout time.Duration) (time.Duration, bool, error) {
+	image := r.buildPullImage(runner)
+	changed := false
+	if runner.Status.BuildTimeouts != nil && (runner.Status.BuildTimeouts.Image != image || meta.IsStatusConditionTrue(runner.Status.Conditions, conditionImageBuilt)) {
+		runner.Status.BuildTimeouts = nil
+		changed = true
+	}
+
+	pods, err := r.listRunnerPods(ctx, runner)
+	if err != nil {
+		return 0, changed, err
+	}
+
+	var next time.Duration
+	for _, pod := range pods {
+		pod := pod
+
+		if !podRunsImage(&pod, image) {
+			continue
+		}
+		for _, status := range pod.Status.InitContainerStatuses {
+			if status.Name != containerName || status.State.Running == nil {
+				continue
+			}
+
+			remaining := timeout - time.Since(status.State.Running.StartedAt.Time)
+			if timeouts := runner.Status.BuildTimeouts; timeouts != nil {
+				if backoff := buildTimeoutBackoff(timeouts.Count) - time.Since(timeouts.LastTimeoutTime.Time); backoff > remaining {
+					remaining = backoff
+				}
+			}
+			if remaining > 0 {
+				if next == 0 || remaining < next {
+					next = remaining
+				}
+				continue
+			}
+
+			if err := r.Client.Delete(ctx, &pod); err != nil && !apierrors.IsNotFound(err) {
+				return 0, changed, err
+			}
+			if runner.Status.BuildTimeouts == nil {
+				runner.Status.BuildTimeouts = &garV1.BuildTimeoutStatus{
+					Image: image,
+				}
+			}
+			runner.Status.BuildTimeouts.Count++
+			runner.Status.BuildTimeouts.LastTimeoutTime = metaV1.Now()
+			meta.SetStatusCondition(&runner.Status.Conditions, metaV1.Condition{
+				Type:               conditionImageBuilt,
+				Status:             metaV1.ConditionFalse,
+				Reason:             "BuildTimedOut",
+				Message:            fmt.Sprintf("Build of %s timed out %d times in a row after %s", image, runner.Status.BuildTimeouts.Count, timeout),
+				ObservedGeneration: runner.Generation,
+			})
+			changed = true
+			builds.WithLabelValues(runner.Namespace, runner.Name, "failed").Inc()
+			r.Recorder.Eventf(runner, coreV1.EventTypeWarning, "BuildTimedOut", "Deleted pod %q building longer than %s, retrying after %s", pod.Name, timeout, buildTimeoutBackoff(runner.Status.BuildTimeouts.Count))
+		}
+	}
+
+	return next, changed, nil
+}
+
+// buildTimeoutBackoff returns how long to wait after the last timed out build before deleting the pod of the next one,
+// doubling from a minute up to an hour.
+func buildTimeoutBackoff(count int32) time.Duration {
+	if count <= 0 {
+		return 0
+	}
+	if count > 7 {
+		return time.Hour
+	}
+	backoff := time.Minute << (count - 1)
+	if backoff > time.Hour {
+		return time.Hour
+	}
+	return backoff
+}
+
 func (r *RunnerReconciler) cleanupOwnedResources(ctx context.Context, runner *garV1.Runner) error {
 	var configMaps v1.ConfigMapList
 	if err := r.List(
@@ -636,10 +1235,11 @@ func (r *RunnerReconciler) cleanupOwnedResources(ctx context.Context, runner *ga
 		return err
 	}
 
+	deploymentNames := ownedDeploymentNames(runner)
 	for _, deployment := range deployments.Items {
 		deployment := deployment
 
-		if deployment.Name == runner.Name+"-runner" {
+		if _, ok := deploymentNames[deployment.Name]; ok {
 			continue
 		}
 
@@ -649,10 +1249,84 @@ func (r *RunnerReconciler) cleanupOwnedResources(ctx context.Context, runner *ga
 		r.Recorder.Eventf(runner, coreV1.EventTypeNormal, "SuccessfulDeleted", "Deleted deployment: %q", deployment.Name)
 	}
 
+	var autoscalers autoscalingV2.HorizontalPodAutoscalerList
+	if err := r.List(
+		ctx,
+		&autoscalers,
+		client.InNamespace(runner.Namespace),
+		client.MatchingFields{ownerKey: runner.Name},
+	); err != nil {
+		return err
+	}
+
+	autoscalerNames := ownedHorizontalPodAutoscalerNames(runner)
+	for _, autoscaler := range autoscalers.Items {
+		autoscaler := autoscaler
+
+		if _, ok := autoscalerNames[autoscaler.Name]; ok {
+			continue
+		}
+
+		if err := r.Client.Delete(ctx, &autoscaler); err != nil {
+			return err
+		}
+		r.Recorder.Eventf(runner, coreV1.EventTypeNormal, "SuccessfulDeleted", "Deleted horizontal pod autoscaler: %q", autoscaler.Name)
+	}
+
+	var statefulSets appsV1.StatefulSetList
+	if err := r.List(
+		ctx,
+		&statefulSets,
+		client.InNamespace(runner.Namespace),
+		client.MatchingFields{ownerKey: runner.Name},
+	); err != nil {
+		return err
+	}
+
+	for _, statefulSet := range statefulSets.Items {
+		statefulSet := statefulSet
+
+		if statefulSet.Name == runner.Name+"-runner" && usesStatefulSet(runner) {
+			continue
+		}
+
+		if err := r.Client.Delete(ctx, &statefulSet); err != nil {
+			return err
+		}
+		r.Recorder.Eventf(runner, coreV1.EventTypeNormal, "SuccessfulDeleted", "Deleted stateful set: %q", statefulSet.Name)
+	}
+
+	var claims v1.PersistentVolumeClaimList
+	if err := r.List(
+		ctx,
+		&claims,
+		client.InNamespace(runner.Namespace),
+		client.MatchingFields{ownerKey: runner.Name},
+	); err != nil {
+		return err
+	}
+
+	for _, claim := range claims.Items {
+		claim := claim
+
+		if claim.Name == runner.Name+"-tool-cache" && runner.Spec.Cache.ToolCache != nil {
+			continue
+		}
+
+		if err := r.Client.Delete(ctx, &claim); err != nil {
+			return err
+		}
+		r.Recorder.Eventf(runner, coreV1.EventTypeNormal, "SuccessfulDeleted", "Deleted persistent volume claim: %q", claim.Name)
+	}
+
 	return nil
 }
 
 func (r *RunnerReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := validateBuilder(r.Builder); err != nil {
+		return err
+	}
+
 	ctx := context.Background()
 	if err := mgr.GetFieldIndexer().IndexField(ctx, &v1.ConfigMap{}, ownerKey, func(rawObj client.Object) []string {
 		configMap := rawObj.(*v1.ConfigMap)
@@ -684,11 +1358,114 @@ func (r *RunnerReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		return err
 	}
 
+	if err := mgr.GetFieldIndexer().IndexField(ctx, &appsV1.StatefulSet{}, ownerKey, func(rawObj client.Object) []string {
+		statefulSet := rawObj.(*appsV1.StatefulSet)
+		owner := metaV1.GetControllerOf(statefulSet)
+		if owner == nil {
+			return nil
+		}
+		if owner.Kind != "Runner" {
+			return nil
+		}
+
+		return []string{owner.Name}
+	}); err != nil {
+		return err
+	}
+
+	if err := mgr.GetFieldIndexer().IndexField(ctx, &autoscalingV2.HorizontalPodAutoscaler{}, ownerKey, func(rawObj client.Object) []string {
+		autoscaler := rawObj.(*autoscalingV2.HorizontalPodAutoscaler)
+		owner := metaV1.GetControllerOf(autoscaler)
+		if owner == nil {
+			return nil
+		}
+		if owner.Kind != "Runner" {
+			return nil
+		}
+
+		return []string{owner.Name}
+	}); err != nil {
+		return err
+	}
+
+	if err := mgr.GetFieldIndexer().IndexField(ctx, &v1.PersistentVolumeClaim{}, ownerKey, func(rawObj client.Object) []string {
+		claim := rawObj.(*v1.PersistentVolumeClaim)
+		owner := metaV1.GetControllerOf(claim)
+		if owner == nil {
+			return nil
+		}
+		if owner.Kind != "Runner" {
+			return nil
+		}
+
+		return []string{owner.Name}
+	}); err != nil {
+		return err
+	}
+
+	if err := mgr.GetFieldIndexer().IndexField(ctx, &garV1.Runner{}, runnerClassNameKey, func(rawObj client.Object) []string {
+		runner := rawObj.(*garV1.Runner)
+		if runner.Spec.RunnerClassName == "" {
+			return nil
+		}
+
+		return []string{runner.Spec.RunnerClassName}
+	}); err != nil {
+		return err
+	}
+
+	if err := mgr.GetFieldIndexer().IndexField(ctx, &garV1.Runner{}, repositoryKey, func(rawObj client.Object) []string {
+		runner := rawObj.(*garV1.Runner)
+		// Repository names of GitHub are case-insensitive
+		return []string{strings.ToLower(runner.Spec.Repository)}
+	}); err != nil {
+		return err
+	}
+
+	if r.WebhookAddress != "" {
+		if err := mgr.Add(webhookRunnable{reconciler: r}); err != nil {
+			return err
+		}
+	}
+
+	if r.RegistryGarbageCollectionInterval > 0 {
+		if err := mgr.Add(manager.RunnableFunc(r.startRegistryGarbageCollection)); err != nil {
+			return err
+		}
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&garV1.Runner{}).
-		Owns(&v1.ConfigMap{}).
-		Owns(&appsV1.Deployment{}).
-		WithEventFilter(predicate.GenerationChangedPredicate{}).
+		// Annotations are watched for wakeups by the webhook
+		For(&garV1.Runner{}, ctrlBuilder.WithPredicates(predicate.Or(predicate.GenerationChangedPredicate{}, predicate.AnnotationChangedPredicate{}))).
+		Owns(&v1.ConfigMap{}, ctrlBuilder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Owns(&appsV1.Deployment{}, ctrlBuilder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Owns(&appsV1.StatefulSet{}, ctrlBuilder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Owns(&autoscalingV2.HorizontalPodAutoscaler{}, ctrlBuilder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		// Signing jobs are watched for their completion, which does not change their generation
+		Owns(&batchV1.Job{}).
+		Watches(
+			&garV1.RunnerClass{},
+			handler.EnqueueRequestsFromMapFunc(r.mapRunnerClassToRunners),
+			ctrlBuilder.WithPredicates(predicate.GenerationChangedPredicate{}),
+		).
+		Watches(
+			&v1.Pod{},
+			handler.EnqueueRequestsFromMapFunc(mapPodToRunner),
+			ctrlBuilder.WithPredicates(predicate.Funcs{
+				CreateFunc: func(event.CreateEvent) bool {
+					return false
+				},
+				UpdateFunc: func(e event.UpdateEvent) bool {
+					return !reflect.DeepEqual(e.ObjectOld.(*v1.Pod).Status.InitContainerStatuses, e.ObjectNew.(*v1.Pod).Status.InitContainerStatuses)
+				},
+				DeleteFunc: func(event.DeleteEvent) bool {
+					return false
+				},
+				GenericFunc: func(event.GenericEvent) bool {
+					return false
+				},
+			}),
+		).
 		WithOptions(controller.Options{MaxConcurrentReconciles: 1}).
 		Complete(r)
 }
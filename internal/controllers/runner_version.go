@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	garV1 "github-actions-runner-controller/api/v1"
+
+	"golang.org/x/xerrors"
+)
+
+const latestRunnerVersion = "latest"
+
+// runnerVersionCache shares the latest release of GitHub Actions runner among runners to save API rate limit.
+type runnerVersionCache struct {
+	mu        sync.Mutex
+	version   string
+	fetchedAt time.Time
+}
+
+// get returns the latest release fetched within ttl, or the first one fetched forever if ttl is 0.
+func (c *runnerVersionCache) get(ctx context.Context, ttl time.Duration) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.version != "" && (ttl <= 0 || time.Since(c.fetchedAt) < ttl) {
+		return c.version, nil
+	}
+
+	version, err := getLatestRunnerVersion(ctx)
+	if err != nil {
+		return "", err
+	}
+	c.version = version
+	c.fetchedAt = time.Now()
+	return version, nil
+}
+
+func getLatestRunnerVersion(ctx context.Context) (string, error) {
+	request, err := http.NewRequestWithContext(ctx, "GET", "https://api.github.com/repos/actions/runner/releases/latest", nil)
+	if err != nil {
+		return "", xerrors.Errorf("failed to create request: %w", err)
+	}
+	request.Header.Set("Accept", "application/vnd.github+json")
+	request.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	response, err := doGitHubRequest(request, "releases")
+	if err != nil {
+		return "", xerrors.Errorf("failed to do request: %w", err)
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	if response.StatusCode != http.StatusOK {
+		return "", xerrors.Errorf("failed to get latest release: %d", response.StatusCode)
+	}
+
+	release := struct {
+		TagName string `json:"tag_name"`
+	}{}
+	if err := json.NewDecoder(response.Body).Decode(&release); err != nil {
+		return "", xerrors.Errorf("failed to decode latest release: %w", err)
+	}
+	if release.TagName == "" {
+		return "", xerrors.New("failed to find tag of latest release")
+	}
+
+	return strings.TrimPrefix(release.TagName, "v"), nil
+}
+
+// runnerVersion returns the effective version of GitHub Actions runner, where latest is the one resolved at the last refresh.
+func (r *RunnerReconciler) runnerVersion(runner *garV1.Runner) string {
+	switch runner.Spec.RunnerVersion {
+	case "":
+		return r.RunnerVersion
+	case latestRunnerVersion:
+		if runner.Status.RunnerVersion != "" {
+			return runner.Status.RunnerVersion
+		}
+		return r.RunnerVersion
+	default:
+		return runner.Spec.RunnerVersion
+	}
+}
+
+func (r *RunnerReconciler) disableUpdate(runner *garV1.Runner) bool {
+	if runner.Spec.DisableUpdate != nil {
+		return *runner.Spec.DisableUpdate
+	}
+	return r.Disableupdate
+}
@@ -0,0 +1,290 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	garV1 "github-actions-runner-controller/api/v1"
+
+	"github.com/go-logr/logr"
+	"golang.org/x/xerrors"
+	coreV1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	ctrlBuilder "sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+const (
+	// Runner names are limited so that names and labels derived from them, such as <name>-runner-canary, stay within 63 characters
+	maxRunnerNameLength    = 45
+	defaultRefreshInterval = 10 * time.Minute
+)
+
+var invalidNameCharacters = regexp.MustCompile(`[^a-z0-9-]+`)
+
+type RunnerSetReconciler struct {
+	client.Client
+	Log                     logr.Logger
+	Scheme                  *runtime.Scheme
+	Recorder                record.EventRecorder
+	GitHubAppClientId       string
+	GitHubAppInstallationId string
+	GitHubAppPrivateKey     string
+}
+
+func (r *RunnerSetReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var requeueAfter time.Duration
+
+	runnerSet := &garV1.RunnerSet{}
+	logger := r.Log.WithValues("runnerset", req.NamespacedName)
+	if err := r.Get(ctx, req.NamespacedName, runnerSet); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	if runnerSet.Spec.Selector != nil {
+		refreshInterval := defaultRefreshInterval
+		if runnerSet.Spec.RefreshInterval != nil && runnerSet.Spec.RefreshInterval.Duration > 0 {
+			refreshInterval = runnerSet.Spec.RefreshInterval.Duration
+		}
+		if runnerSet.Status.ObservedGeneration != runnerSet.Generation || runnerSet.Status.RefreshedAt == nil || time.Since(runnerSet.Status.RefreshedAt.Time) >= refreshInterval {
+			selected, err := r.selectRepositories(ctx, runnerSet)
+			if err != nil {
+				r.Recorder.Eventf(runnerSet, coreV1.EventTypeWarning, "FailedRefresh", "Failed to select repositories: %v", err)
+				logger.Error(err, "failed to select repositories")
+			} else {
+				runnerSet.Status.SelectedRepositories = selected
+				runnerSet.Status.ObservedGeneration = runnerSet.Generation
+			}
+			runnerSet.Status.RefreshedAt = &metaV1.Time{Time: time.Now()}
+
+			if err := r.Status().Update(ctx, runnerSet); err != nil {
+				if strings.Contains(err.Error(), optimisticLockErrorMsg) {
+					return ctrl.Result{RequeueAfter: time.Second}, nil
+				}
+				return ctrl.Result{}, err
+			}
+			logger.V(1).Info("update", "status", runnerSet.Status)
+		}
+		requeueAfter = shorterRequeueAfter(requeueAfter, time.Until(runnerSet.Status.RefreshedAt.Add(refreshInterval)))
+	}
+
+	var repositories []string
+	for _, repository := range runnerSet.Spec.Repositories {
+		repositories = append(repositories, string(repository))
+	}
+	if runnerSet.Spec.Selector != nil {
+		repositories = append(repositories, runnerSet.Status.SelectedRepositories...)
+	}
+	expectedRunners := map[string]*garV1.Runner{}
+	for _, repository := range repositories {
+		runner := buildSetRunner(runnerSet, repository)
+		if existing, ok := expectedRunners[runner.Name]; ok {
+			if !strings.EqualFold(existing.Spec.Repository, repository) {
+				r.Recorder.Eventf(runnerSet, coreV1.EventTypeWarning, "NameCollision", "Repositories %q and %q are given the same runner name %q, and only the former is run", existing.Spec.Repository, repository, runner.Name)
+			}
+			continue
+		}
+		expectedRunners[runner.Name] = runner
+	}
+
+	for _, expected := range expectedRunners {
+		var runner garV1.Runner
+		if err := r.Client.Get(ctx, client.ObjectKeyFromObject(expected), &runner); apierrors.IsNotFound(err) {
+			if err := controllerutil.SetControllerReference(runnerSet, expected, r.Scheme); err != nil {
+				return ctrl.Result{}, err
+			}
+			if err := r.Create(ctx, expected); err != nil {
+				return ctrl.Result{}, err
+			}
+			r.Recorder.Eventf(runnerSet, coreV1.EventTypeNormal, "SuccessfulCreated", "Created runner: %q", expected.Name)
+			logger.V(1).Info("create", "runner", expected)
+			continue
+		} else if err != nil {
+			return ctrl.Result{}, err
+		}
+
+		if !metaV1.IsControlledBy(&runner, runnerSet) {
+			r.Recorder.Eventf(runnerSet, coreV1.EventTypeWarning, "FailedCreated", "Runner %q already exists and is not owned by the runner set", runner.Name)
+			continue
+		}
+		// The wakeup of the webhook is not part of the template
+		if wokenAt, ok := runner.Annotations[wokenAtAnnotation]; ok {
+			annotations := map[string]string{wokenAtAnnotation: wokenAt}
+			for k, v := range expected.Annotations {
+				annotations[k] = v
+			}
+			expected.Annotations = annotations
+		}
+		if reflect.DeepEqual(runner.Spec, expected.Spec) &&
+			reflect.DeepEqual(runner.Labels, expected.Labels) &&
+			reflect.DeepEqual(runner.Annotations, expected.Annotations) {
+			continue
+		}
+		runner.Labels = expected.Labels
+		runner.Annotations = expected.Annotations
+		runner.Spec = expected.Spec
+		if err := r.Update(ctx, &runner); err != nil {
+			if strings.Contains(err.Error(), optimisticLockErrorMsg) {
+				return ctrl.Result{RequeueAfter: time.Second}, nil
+			}
+			return ctrl.Result{}, err
+		}
+		r.Recorder.Eventf(runnerSet, coreV1.EventTypeNormal, "SuccessfulUpdated", "Updated runner: %q", runner.Name)
+		logger.V(1).Info("update", "runner", runner)
+	}
+
+	var runners garV1.RunnerList
+	if err := r.List(
+		ctx,
+		&runners,
+		client.InNamespace(runnerSet.Namespace),
+		client.MatchingFields{ownerKey: runnerSet.Name},
+	); err != nil {
+		return ctrl.Result{}, err
+	}
+	for _, runner := range runners.Items {
+		runner := runner
+		if _, ok := expectedRunners[runner.Name]; ok {
+			continue
+		}
+		if err := r.Delete(ctx, &runner); err != nil && !apierrors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		r.Recorder.Eventf(runnerSet, coreV1.EventTypeNormal, "SuccessfulDeleted", "Deleted runner: %q", runner.Name)
+	}
+
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// selectRepositories lists repositories selected by the selector from GitHub, sorted by name.
+func (r *RunnerSetReconciler) selectRepositories(ctx context.Context, runnerSet *garV1.RunnerSet) ([]string, error) {
+	selector := runnerSet.Spec.Selector
+	var nameRegex *regexp.Regexp
+	if selector.NameRegex != "" {
+		var err error
+		nameRegex, err = regexp.Compile(selector.NameRegex)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to compile nameRegex: %w", err)
+		}
+	}
+
+	var candidates []repository
+	if tokenSecretKeyRef := runnerSet.Spec.Template.Spec.TokenSecretKeyRef; tokenSecretKeyRef != nil {
+		token, err := getSecretToken(ctx, r, runnerSet.Namespace, tokenSecretKeyRef)
+		if err != nil {
+			return nil, err
+		}
+		candidates, err = listUserRepositories(ctx, token)
+		if err != nil {
+			return nil, err
+		}
+	} else if r.GitHubAppClientId != "" && r.GitHubAppInstallationId != "" && r.GitHubAppPrivateKey != "" {
+		start := time.Now()
+		token, _, err := mintInstallationToken(r.GitHubAppPrivateKey, r.GitHubAppClientId, r.GitHubAppInstallationId, nil, map[string]string{
+			"metadata": "read",
+		})
+		observeTokenMint(start, err)
+		if err != nil {
+			return nil, err
+		}
+		candidates, err = listInstallationRepositories(ctx, token)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, xerrors.New("no credentials to list repositories")
+	}
+
+	var selected []string
+	for _, candidate := range candidates {
+		if candidate.Archived {
+			continue
+		}
+		if selector.Owner != "" && !strings.EqualFold(strings.SplitN(candidate.FullName, "/", 2)[0], selector.Owner) {
+			continue
+		}
+		if nameRegex != nil && !nameRegex.MatchString(candidate.FullName) {
+			continue
+		}
+		if selector.Topic != "" && !containsString(candidate.Topics, selector.Topic) {
+			continue
+		}
+		selected = append(selected, candidate.FullName)
+	}
+	sort.Strings(selected)
+	return selected, nil
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// buildSetRunner returns the runner of the repository stamped out from the template of the runner set.
+func buildSetRunner(runnerSet *garV1.RunnerSet, repository string) *garV1.Runner {
+	spec := runnerSet.Spec.Template.Spec.DeepCopy()
+	spec.Repository = repository
+	return &garV1.Runner{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:        setRunnerName(runnerSet, repository),
+			Namespace:   runnerSet.Namespace,
+			Labels:      runnerSet.Spec.Template.Labels,
+			Annotations: runnerSet.Spec.Template.Annotations,
+		},
+		Spec: *spec,
+	}
+}
+
+// setRunnerName returns <set>-<owner>-<repository> made a valid name, shortened if too long, and suffixed with a hash of the repository,
+// as repositories such as org/a-b and org/a.b are otherwise given the same name.
+// Names of repositories are case-insensitive on GitHub, and so is the hash.
+func setRunnerName(runnerSet *garV1.RunnerSet, repository string) string {
+	hash := fmt.Sprintf("%x", sha256.Sum256([]byte(strings.ToLower(repository))))[:7]
+	name := strings.Trim(invalidNameCharacters.ReplaceAllString(strings.ToLower(runnerSet.Name+"-"+repository), "-"), "-")
+	if len(name) > maxRunnerNameLength-len(hash)-1 {
+		name = strings.TrimRight(name[:maxRunnerNameLength-len(hash)-1], "-")
+	}
+	return name + "-" + hash
+}
+
+func (r *RunnerSetReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	ctx := context.Background()
+	if err := mgr.GetFieldIndexer().IndexField(ctx, &garV1.Runner{}, ownerKey, func(rawObj client.Object) []string {
+		runner := rawObj.(*garV1.Runner)
+		owner := metaV1.GetControllerOf(runner)
+		if owner == nil {
+			return nil
+		}
+		if owner.Kind != "RunnerSet" {
+			return nil
+		}
+
+		return []string{owner.Name}
+	}); err != nil {
+		return err
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&garV1.RunnerSet{}, ctrlBuilder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Owns(&garV1.Runner{}, ctrlBuilder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Complete(r)
+}
@@ -0,0 +1,225 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	garV1 "github-actions-runner-controller/api/v1"
+
+	"github.com/go-logr/logr"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+func TestSetRunnerName(t *testing.T) {
+	type in struct {
+		runnerSet  string
+		repository string
+	}
+
+	type want struct {
+		name string
+	}
+
+	cases := []struct {
+		name string
+		in   in
+		want want
+	}{
+		{
+			"names runners after the runner set and the repository",
+			in{
+				"set",
+				"kaidotdev/runner",
+			},
+			want{
+				"set-kaidotdev-runner-29eef89",
+			},
+		},
+		{
+			"hashes repositories case-insensitively",
+			in{
+				"set",
+				"KaiDotDev/Runner",
+			},
+			want{
+				"set-kaidotdev-runner-29eef89",
+			},
+		},
+		{
+			"suffixes names with a hash of the repository",
+			in{
+				"set",
+				"org/a-b",
+			},
+			want{
+				"set-org-a-b-18f07d3",
+			},
+		},
+		{
+			"tells apart repositories made the same name by invalid characters",
+			in{
+				"set",
+				"org/a.b",
+			},
+			want{
+				"set-org-a-b-763fc93",
+			},
+		},
+		{
+			"shortens long names within maxRunnerNameLength",
+			in{
+				"set",
+				"kaidotdev/a-very-long-repository-name-exceeding-the-limit",
+			},
+			want{
+				"set-kaidotdev-a-very-long-repository-f221ef0",
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			runnerSet := &garV1.RunnerSet{
+				ObjectMeta: metaV1.ObjectMeta{
+					Name: tc.in.runnerSet,
+				},
+			}
+			got := setRunnerName(runnerSet, tc.in.repository)
+			if got != tc.want.name {
+				t.Errorf("name: want %q, got %q", tc.want.name, got)
+			}
+			if len(got) > maxRunnerNameLength {
+				t.Errorf("length: want at most %d, got %d", maxRunnerNameLength, len(got))
+			}
+		})
+	}
+}
+
+func TestRunnerSetReconcile(t *testing.T) {
+	type in struct {
+		repositories []garV1.RepositoryName
+		owned        []string
+		unowned      []string
+	}
+
+	type want struct {
+		runners []string
+	}
+
+	cases := []struct {
+		name string
+		in   in
+		want want
+	}{
+		{
+			"creates runners of added repositories",
+			in{
+				[]garV1.RepositoryName{"kaidotdev/a", "kaidotdev/b"},
+				[]string{"kaidotdev/a"},
+				nil,
+			},
+			want{
+				[]string{"kaidotdev/a", "kaidotdev/b"},
+			},
+		},
+		{
+			"deletes runners of removed repositories",
+			in{
+				[]garV1.RepositoryName{"kaidotdev/a"},
+				[]string{"kaidotdev/a", "kaidotdev/b"},
+				nil,
+			},
+			want{
+				[]string{"kaidotdev/a"},
+			},
+		},
+		{
+			"never deletes runners not owned by the runner set",
+			in{
+				nil,
+				[]string{"kaidotdev/a"},
+				[]string{"kaidotdev/b"},
+			},
+			want{
+				[]string{"kaidotdev/b"},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			scheme := runtime.NewScheme()
+			if err := garV1.AddToScheme(scheme); err != nil {
+				t.Fatal(err)
+			}
+			runnerSet := &garV1.RunnerSet{
+				ObjectMeta: metaV1.ObjectMeta{
+					Name:      "set",
+					Namespace: "default",
+					UID:       "set",
+				},
+				Spec: garV1.RunnerSetSpec{
+					Repositories: tc.in.repositories,
+				},
+			}
+			objects := []client.Object{runnerSet}
+			for _, repository := range tc.in.owned {
+				runner := buildSetRunner(runnerSet, repository)
+				if err := controllerutil.SetControllerReference(runnerSet, runner, scheme); err != nil {
+					t.Fatal(err)
+				}
+				objects = append(objects, runner)
+			}
+			for _, repository := range tc.in.unowned {
+				objects = append(objects, buildSetRunner(runnerSet, repository))
+			}
+			reconciler := &RunnerSetReconciler{
+				Client: fake.NewClientBuilder().
+					WithScheme(scheme).
+					WithObjects(objects...).
+					WithIndex(&garV1.Runner{}, ownerKey, func(rawObj client.Object) []string {
+						owner := metaV1.GetControllerOf(rawObj)
+						if owner == nil || owner.Kind != "RunnerSet" {
+							return nil
+						}
+						return []string{owner.Name}
+					}).
+					Build(),
+				Log:      logr.Discard(),
+				Scheme:   scheme,
+				Recorder: record.NewFakeRecorder(10),
+			}
+
+			if _, err := reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(runnerSet)}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var runners garV1.RunnerList
+			if err := reconciler.List(context.Background(), &runners, client.InNamespace("default")); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, runner := range runners.Items {
+				got = append(got, runner.Spec.Repository)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tc.want.runners) {
+				t.Errorf("runners: want %v, got %v", tc.want.runners, got)
+			}
+		})
+	}
+}
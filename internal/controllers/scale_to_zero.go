@@ -0,0 +1,215 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"time"
+
+	garV1 "github-actions-runner-controller/api/v1"
+
+	appsV1 "k8s.io/api/apps/v1"
+	coreV1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	defaultIdleTimeout = 10 * time.Minute
+	defaultRunnerLabel = "kaidotdev/github-actions-runner-controller"
+)
+
+// scalePoolsByQueue scales deployments of pools with minReplicas 0 up to the number of their queued and running jobs,
+// and back down to the size of their warm pools after they have been idle for idleTimeout.
+// It returns the time to check the pools again.
+func (r *RunnerReconciler) scalePoolsByQueue(ctx context.Context, runner *garV1.Runner, lister *repositoryRunnerLister) (time.Duration, error) {
+	logger := r.Log.WithValues("runner", client.ObjectKeyFromObject(runner))
+
+	var pools []*garV1.Pool
+	for i := range runner.Spec.Pools {
+		if scalesByQueue(&runner.Spec.Pools[i]) {
+			pools = append(pools, &runner.Spec.Pools[i])
+		}
+	}
+	if len(pools) == 0 {
+		if runner.Status.Pools == nil {
+			return 0, nil
+		}
+		runner.Status.Pools = nil
+		return 0, r.updateStatus(ctx, runner)
+	}
+
+	pollAfter := r.QueuePollInterval
+	busy, queued, err := r.countPoolJobs(ctx, runner, pools, lister)
+	if err != nil {
+		// Jobs are counted again at the next poll
+		logger.Error(err, "failed to count jobs of pools")
+		return pollAfter, nil
+	}
+
+	now := time.Now()
+	var statuses []garV1.PoolStatus
+	for _, pool := range pools {
+		status := garV1.PoolStatus{
+			Name: pool.Name,
+		}
+		for _, previous := range runner.Status.Pools {
+			if previous.Name == pool.Name {
+				status.IdleSince = previous.IdleSince
+			}
+		}
+
+		var deployment appsV1.Deployment
+		if err := r.Get(ctx, client.ObjectKey{Name: poolDeploymentName(runner, pool), Namespace: runner.Namespace}, &deployment); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return 0, err
+		}
+		var current int32
+		if deployment.Spec.Replicas != nil {
+			current = *deployment.Spec.Replicas
+		}
+
+		desired := current
+		if demand := busy[pool.Name] + queued[pool.Name]; demand > 0 {
+			status.IdleSince = nil
+			if demand > desired {
+				desired = demand
+			}
+		} else {
+			if status.IdleSince == nil {
+				status.IdleSince = &metaV1.Time{Time: now}
+			}
+			idleTimeout := defaultIdleTimeout
+			if pool.Autoscaling.IdleTimeout != nil {
+				idleTimeout = pool.Autoscaling.IdleTimeout.Duration
+			}
+			if remaining := status.IdleSince.Add(idleTimeout).Sub(now); remaining > 0 {
+				pollAfter = shorterRequeueAfter(pollAfter, remaining)
+			} else {
+				desired = 0
+			}
+		}
+
+		warm, changeAt, err := warmReplicas(pool.Autoscaling.WarmPools, now)
+		if err != nil {
+			r.Recorder.Eventf(runner, coreV1.EventTypeWarning, "FailedRescale", "Failed to evaluate warm pools of pool %q: %v", pool.Name, err)
+			logger.Error(err, "failed to evaluate warm pools", "pool", pool.Name)
+		}
+		if !changeAt.IsZero() {
+			pollAfter = shorterRequeueAfter(pollAfter, changeAt.Sub(now))
+		}
+		if warm > desired {
+			desired = warm
+		}
+		if desired > pool.Autoscaling.MaxReplicas {
+			desired = pool.Autoscaling.MaxReplicas
+		}
+		status.Replicas = desired
+		statuses = append(statuses, status)
+
+		if desired == current {
+			continue
+		}
+		deployment.Spec.Replicas = &desired
+		if err := r.Update(ctx, &deployment); err != nil {
+			return 0, err
+		}
+		r.Recorder.Eventf(runner, coreV1.EventTypeNormal, "SuccessfulRescale", "Scaled deployment %q from %d to %d with %d queued and %d running jobs", deployment.Name, current, desired, queued[pool.Name], busy[pool.Name])
+		logger.V(1).Info("scale", "deployment", deployment.Name, "replicas", desired)
+	}
+
+	if !reflect.DeepEqual(runner.Status.Pools, statuses) {
+		runner.Status.Pools = statuses
+		if err := r.updateStatus(ctx, runner); err != nil {
+			return 0, err
+		}
+	}
+	return pollAfter, nil
+}
+
+// countPoolJobs counts running jobs of runners of each pool, and queued jobs each pool can run by labels.
+// A queued job matching several pools is counted for all of them.
+func (r *RunnerReconciler) countPoolJobs(ctx context.Context, runner *garV1.Runner, pools []*garV1.Pool, lister *repositoryRunnerLister) (map[string]int32, map[string]int32, error) {
+	token, repositoryRunners, err := lister.list(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	jobs, err := listQueuedWorkflowJobs(ctx, token, runner.Spec.Repository)
+	if err != nil {
+		return nil, nil, err
+	}
+	pods, err := r.listRunnerPods(ctx, runner)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	podPools := map[string]string{}
+	for _, pod := range pods {
+		podPools[pod.Name] = pod.Labels["pool"]
+	}
+	busy := map[string]int32{}
+	for _, repositoryRunner := range repositoryRunners {
+		if repositoryRunner.Busy {
+			busy[podPools[repositoryRunner.Name]]++
+		}
+	}
+
+	queued := map[string]int32{}
+	for _, pool := range pools {
+		labels := poolRunnerLabels(runner, pool)
+		for _, job := range jobs {
+			if runsOn(job, labels) {
+				queued[pool.Name]++
+			}
+		}
+	}
+	return busy, queued, nil
+}
+
+// poolRunnerLabels returns labels of runners of the pool in lower case, including the default labels added by GitHub Actions runner.
+func poolRunnerLabels(runner *garV1.Runner, pool *garV1.Pool) map[string]struct{} {
+	arch := "x64"
+	if architecture(runner) == "arm64" {
+		arch = "arm64"
+	}
+	labels := map[string]struct{}{}
+	for _, label := range append([]string{"self-hosted", "linux", arch, defaultRunnerLabel}, poolLabels(pool)...) {
+		labels[strings.ToLower(label)] = struct{}{}
+	}
+	return labels
+}
+
+// runsOn reports whether the job can run on a runner with the labels, which GitHub matches case-insensitively.
+func runsOn(job workflowJob, labels map[string]struct{}) bool {
+	if len(job.Labels) == 0 {
+		return false
+	}
+	for _, label := range job.Labels {
+		if _, ok := labels[strings.ToLower(label)]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// warmReplicas returns the largest number of runners of warm pools whose windows contain now, and the time any window next starts or ends.
+func warmReplicas(warmPools []garV1.WarmPool, now time.Time) (int32, time.Time, error) {
+	var replicas int32
+	var changeAt time.Time
+	for _, warmPool := range warmPools {
+		active, next, err := scheduleWindow(warmPool.Schedule, warmPool.TimeZone, warmPool.Duration.Duration, now)
+		if err != nil {
+			return replicas, changeAt, err
+		}
+		if active && warmPool.Replicas > replicas {
+			replicas = warmPool.Replicas
+		}
+		if !next.IsZero() && (changeAt.IsZero() || next.Before(changeAt)) {
+			changeAt = next
+		}
+	}
+	return replicas, changeAt, nil
+}
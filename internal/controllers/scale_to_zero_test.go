@@ -0,0 +1,226 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	garV1 "github-actions-runner-controller/api/v1"
+
+	coreV1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestRunsOn(t *testing.T) {
+	labels := poolRunnerLabels(&garV1.Runner{}, &garV1.Pool{
+		Name:   "gpu",
+		Labels: []garV1.RunnerLabel{"GPU"},
+	})
+
+	type in struct {
+		labels []string
+	}
+
+	type want struct {
+		runs bool
+	}
+
+	cases := []struct {
+		name string
+		in   in
+		want want
+	}{
+		{
+			"runs jobs on default labels",
+			in{
+				[]string{"self-hosted", "linux"},
+			},
+			want{
+				true,
+			},
+		},
+		{
+			"matches labels case-insensitively",
+			in{
+				[]string{"Self-Hosted", "Linux", "X64", "gpu"},
+			},
+			want{
+				true,
+			},
+		},
+		{
+			"never runs jobs with labels the runners lack",
+			in{
+				[]string{"self-hosted", "windows"},
+			},
+			want{
+				false,
+			},
+		},
+		{
+			"never runs jobs without labels",
+			in{
+				nil,
+			},
+			want{
+				false,
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := runsOn(workflowJob{Labels: tc.in.labels}, labels); got != tc.want.runs {
+				t.Errorf("runs: want %t, got %t", tc.want.runs, got)
+			}
+		})
+	}
+}
+
+func TestCountPoolJobs(t *testing.T) {
+	type in struct {
+		jobs [][]string
+	}
+
+	type want struct {
+		queued map[string]int32
+	}
+
+	cases := []struct {
+		name string
+		in   in
+		want want
+	}{
+		{
+			"counts queued jobs each pool can run",
+			in{
+				[][]string{{"self-hosted"}, {"Self-Hosted", "Linux", "GPU"}},
+			},
+			want{
+				map[string]int32{"cpu": 1, "gpu": 2},
+			},
+		},
+		{
+			"never counts jobs without labels",
+			in{
+				[][]string{nil},
+			},
+			want{
+				map[string]int32{},
+			},
+		},
+		{
+			"never counts jobs with labels no pool has",
+			in{
+				[][]string{{"self-hosted", "windows"}},
+			},
+			want{
+				map[string]int32{},
+			},
+		},
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var index int
+		if _, err := fmt.Sscanf(strings.TrimPrefix(r.URL.Path, "/repos/kaidotdev/case-"), "%d/", &index); err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var response interface{}
+		if strings.HasSuffix(r.URL.Path, "/actions/runs") {
+			runs := []map[string]int64{}
+			if r.URL.Query().Get("status") == "queued" {
+				runs = append(runs, map[string]int64{"id": 1})
+			}
+			response = map[string]interface{}{"total_count": len(runs), "workflow_runs": runs}
+		} else {
+			var jobs []workflowJob
+			for i, labels := range cases[index].in.jobs {
+				jobs = append(jobs, workflowJob{ID: int64(i), Status: "queued", Labels: labels})
+			}
+			response = map[string]interface{}{"total_count": len(jobs), "jobs": jobs}
+		}
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+
+	original := gitHubAPIURL
+	gitHubAPIURL = server.URL
+	t.Cleanup(func() {
+		gitHubAPIURL = original
+	})
+
+	for i, tc := range cases {
+		i, tc := i, tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			runner := &garV1.Runner{
+				ObjectMeta: metaV1.ObjectMeta{
+					Name:      "runner",
+					Namespace: "default",
+				},
+				Spec: garV1.RunnerSpec{
+					Repository: fmt.Sprintf("kaidotdev/case-%d", i),
+				},
+			}
+			pools := []*garV1.Pool{
+				{Name: "cpu"},
+				{Name: "gpu", Labels: []garV1.RunnerLabel{"gpu"}},
+			}
+			reconciler := &RunnerReconciler{
+				Client: fake.NewClientBuilder().
+					WithObjects(
+						runnerPod("cpu-0", "cpu"),
+						runnerPod("gpu-0", "gpu"),
+						runnerPod("gpu-1", "gpu"),
+					).
+					Build(),
+			}
+			lister := &repositoryRunnerLister{
+				reconciler: reconciler,
+				runner:     runner,
+				listed:     true,
+				token:      "token",
+				runners: []repositoryRunner{
+					{Name: "cpu-0", Busy: true},
+					{Name: "gpu-0", Busy: true},
+					{Name: "gpu-1", Busy: false},
+				},
+			}
+
+			busy, queued, err := reconciler.countPoolJobs(context.Background(), runner, pools, lister)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if want := map[string]int32{"cpu": 1, "gpu": 1}; !reflect.DeepEqual(busy, want) {
+				t.Errorf("busy: want %v, got %v", want, busy)
+			}
+			if !reflect.DeepEqual(queued, tc.want.queued) {
+				t.Errorf("queued: want %v, got %v", tc.want.queued, queued)
+			}
+		})
+	}
+}
+
+func runnerPod(name string, pool string) *coreV1.Pod {
+	return &coreV1.Pod{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels: map[string]string{
+				"app":  "runner-runner",
+				"pool": pool,
+			},
+		},
+	}
+}
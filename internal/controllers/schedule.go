@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	garV1 "github-actions-runner-controller/api/v1"
+
+	"github.com/robfig/cron/v3"
+	"golang.org/x/xerrors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// scheduleWindow reports whether now is within a window which starts at the cron schedule in the time zone and lasts for the duration,
+// and returns the time the window next starts or ends.
+func scheduleWindow(schedule string, timeZone string, duration time.Duration, now time.Time) (bool, time.Time, error) {
+	if timeZone == "" {
+		timeZone = "UTC"
+	}
+	spec, err := cron.ParseStandard(fmt.Sprintf("CRON_TZ=%s %s", timeZone, schedule))
+	if err != nil {
+		return false, time.Time{}, xerrors.Errorf("failed to parse schedule %q: %w", schedule, err)
+	}
+
+	// Windows containing now are those starting after now minus the duration and at or before now,
+	// and as they may overlap, the window of the last of these starts ends last
+	start := spec.Next(now.Add(-duration))
+	if start.IsZero() {
+		return false, time.Time{}, nil
+	}
+	if start.After(now) {
+		return false, start, nil
+	}
+	for {
+		next := spec.Next(start)
+		if next.IsZero() || next.After(now) {
+			break
+		}
+		start = next
+	}
+	return true, start.Add(duration), nil
+}
+
+// applySchedules overrides replicas of pools in memory by the schedules whose windows contain now, the first of them applying to each pool.
+// It returns the active schedules, the time any window next starts or ends, and the error of a schedule which cannot be evaluated, if any.
+func applySchedules(runner *garV1.Runner, now time.Time) ([]garV1.ActiveSchedule, time.Time, error) {
+	var active []garV1.ActiveSchedule
+	var changeAt time.Time
+	var scheduleErr error
+	applied := map[string]struct{}{}
+	for i := range runner.Spec.Schedules {
+		schedule := &runner.Spec.Schedules[i]
+		isActive, next, err := scheduleWindow(schedule.Schedule, schedule.TimeZone, schedule.Duration.Duration, now)
+		if err != nil {
+			scheduleErr = xerrors.Errorf("failed to evaluate schedule %s: %w", schedule.Name, err)
+			continue
+		}
+		if !next.IsZero() && (changeAt.IsZero() || next.Before(changeAt)) {
+			changeAt = next
+		}
+		if !isActive {
+			continue
+		}
+
+		active = append(active, garV1.ActiveSchedule{
+			Name:  schedule.Name,
+			Until: metaV1.Time{Time: next.UTC()},
+		})
+		for j := range runner.Spec.Pools {
+			pool := &runner.Spec.Pools[j]
+			if _, ok := applied[pool.Name]; ok {
+				continue
+			}
+			if len(schedule.Pools) > 0 && !containsString(schedule.Pools, pool.Name) {
+				continue
+			}
+			applied[pool.Name] = struct{}{}
+			applySchedule(pool, schedule)
+		}
+	}
+	return active, changeAt, scheduleErr
+}
+
+// applySchedule bounds replicas of the pool by the schedule, keeping how the pool is scaled.
+func applySchedule(pool *garV1.Pool, schedule *garV1.CapacitySchedule) {
+	switch {
+	case pool.Autoscaling == nil:
+		replicas := int32(1)
+		if pool.Replicas != nil {
+			replicas = *pool.Replicas
+		}
+		if schedule.MinReplicas != nil && replicas < *schedule.MinReplicas {
+			replicas = *schedule.MinReplicas
+		}
+		if schedule.MaxReplicas != nil && replicas > *schedule.MaxReplicas {
+			replicas = *schedule.MaxReplicas
+		}
+		pool.Replicas = &replicas
+	case scalesByQueue(pool):
+		if schedule.MaxReplicas != nil {
+			pool.Autoscaling.MaxReplicas = *schedule.MaxReplicas
+		}
+		// Runners kept by the schedule are those of a warm pool in the same window
+		if schedule.MinReplicas != nil && *schedule.MinReplicas > 0 {
+			pool.Autoscaling.WarmPools = append(pool.Autoscaling.WarmPools, garV1.WarmPool{
+				Schedule: schedule.Schedule,
+				TimeZone: schedule.TimeZone,
+				Duration: schedule.Duration,
+				Replicas: *schedule.MinReplicas,
+			})
+		}
+	default:
+		min := minReplicas(pool.Autoscaling)
+		max := pool.Autoscaling.MaxReplicas
+		if schedule.MinReplicas != nil {
+			min = *schedule.MinReplicas
+		}
+		if schedule.MaxReplicas != nil {
+			max = *schedule.MaxReplicas
+		}
+		// HorizontalPodAutoscaler cannot scale to zero
+		if min < 1 {
+			min = 1
+		}
+		if max < min {
+			max = min
+		}
+		pool.Autoscaling.MinReplicas = &min
+		pool.Autoscaling.MaxReplicas = max
+	}
+}
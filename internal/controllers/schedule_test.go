@@ -0,0 +1,216 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleWindow(t *testing.T) {
+	parse := func(t *testing.T, value string) time.Time {
+		t.Helper()
+
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+
+	type in struct {
+		schedule string
+		timeZone string
+		duration time.Duration
+		now      string
+	}
+
+	type want struct {
+		err      bool
+		active   bool
+		changeAt string
+	}
+
+	cases := []struct {
+		name string
+		in   in
+		want want
+	}{
+		{
+			"within a window",
+			in{
+				"0 9 * * 1-5",
+				"",
+				8 * time.Hour,
+				"2024-06-03T12:00:00Z",
+			},
+			want{
+				false,
+				true,
+				"2024-06-03T17:00:00Z",
+			},
+		},
+		{
+			"at the start of a window",
+			in{
+				"0 9 * * 1-5",
+				"",
+				8 * time.Hour,
+				"2024-06-03T09:00:00Z",
+			},
+			want{
+				false,
+				true,
+				"2024-06-03T17:00:00Z",
+			},
+		},
+		{
+			"at the end of a window",
+			in{
+				"0 9 * * 1-5",
+				"",
+				8 * time.Hour,
+				"2024-06-03T17:00:00Z",
+			},
+			want{
+				false,
+				false,
+				"2024-06-04T09:00:00Z",
+			},
+		},
+		{
+			"between windows",
+			in{
+				"0 9 * * 1-5",
+				"",
+				8 * time.Hour,
+				"2024-06-08T12:00:00Z",
+			},
+			want{
+				false,
+				false,
+				"2024-06-10T09:00:00Z",
+			},
+		},
+		{
+			"overlapping windows end with the last start",
+			in{
+				"0 * * * *",
+				"",
+				3 * time.Hour,
+				"2024-06-03T10:30:00Z",
+			},
+			want{
+				false,
+				true,
+				"2024-06-03T13:00:00Z",
+			},
+		},
+		{
+			"in the time zone",
+			in{
+				"0 9 * * *",
+				"Asia/Tokyo",
+				time.Hour,
+				"2024-06-03T00:30:00Z",
+			},
+			want{
+				false,
+				true,
+				"2024-06-03T01:00:00Z",
+			},
+		},
+		{
+			"after daylight saving time starts",
+			in{
+				"0 9 * * *",
+				"Europe/Berlin",
+				8 * time.Hour,
+				"2024-03-31T08:00:00Z",
+			},
+			want{
+				false,
+				true,
+				"2024-03-31T15:00:00Z",
+			},
+		},
+		{
+			"across the end of daylight saving time",
+			in{
+				"0 0 * * *",
+				"Europe/Berlin",
+				12 * time.Hour,
+				"2024-10-27T09:00:00Z",
+			},
+			want{
+				false,
+				true,
+				"2024-10-27T10:00:00Z",
+			},
+		},
+		{
+			"overlapping windows across the end of daylight saving time",
+			in{
+				"0 * * * *",
+				"Europe/Berlin",
+				3 * time.Hour,
+				"2024-10-27T01:30:00Z",
+			},
+			want{
+				false,
+				true,
+				"2024-10-27T04:00:00Z",
+			},
+		},
+		{
+			"invalid schedule",
+			in{
+				"0 9 * *",
+				"",
+				time.Hour,
+				"2024-06-03T12:00:00Z",
+			},
+			want{
+				true,
+				false,
+				"",
+			},
+		},
+		{
+			"invalid time zone",
+			in{
+				"0 9 * * *",
+				"Nowhere/Nothing",
+				time.Hour,
+				"2024-06-03T12:00:00Z",
+			},
+			want{
+				true,
+				false,
+				"",
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			active, changeAt, err := scheduleWindow(tc.in.schedule, tc.in.timeZone, tc.in.duration, parse(t, tc.in.now))
+			if tc.want.err {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if active != tc.want.active {
+				t.Errorf("active: want %t, got %t", tc.want.active, active)
+			}
+			if want := parse(t, tc.want.changeAt); !changeAt.Equal(want) {
+				t.Errorf("changeAt: want %v, got %v", want, changeAt.UTC())
+			}
+		})
+	}
+}
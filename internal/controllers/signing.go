@@ -0,0 +1,376 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	garV1 "github-actions-runner-controller/api/v1"
+
+	"golang.org/x/xerrors"
+	batchV1 "k8s.io/api/batch/v1"
+	coreV1 "k8s.io/api/core/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	conditionImageSigned = "ImageSigned"
+	sbomPath             = "/signing/sbom.json"
+)
+
+// signingJobName returns the name of the job signing the digest of the runner image.
+func signingJobName(runner *garV1.Runner, digest string) string {
+	hex := strings.TrimPrefix(digest, "sha256:")
+	if len(hex) > 7 {
+		hex = hex[:7]
+	}
+	return runner.Name + "-signing-" + hex
+}
+
+// buildSigningJob returns the job generating the SBOM of the digest of the pushed runner image,
+// and attaching the SBOM attestation and the signature to it in the push registry by cosign.
+// Signing runs apart from runner pods, so that the signing key is never exposed to workflows.
+func (r *RunnerReconciler) buildSigningJob(runner *garV1.Runner, registry garV1.Registry, digest string) *batchV1.Job {
+	signing := runner.Spec.Build.Signing
+	reference := fmt.Sprintf("%s@%s", r.buildPushImage(runner), digest)
+
+	sbomFormat := signing.SBOMFormat
+	if sbomFormat == "" {
+		sbomFormat = "spdx-json"
+	}
+	predicateType := "spdxjson"
+	if sbomFormat == "cyclonedx-json" {
+		predicateType = "cyclonedx"
+	}
+
+	volumes := append([]v1.Volume{
+		{
+			Name: "signing",
+			VolumeSource: v1.VolumeSource{
+				EmptyDir: &v1.EmptyDirVolumeSource{},
+			},
+		},
+	}, buildRegistryVolumes(registry)...)
+	volumeMounts := []v1.VolumeMount{
+		{
+			Name:      "signing",
+			MountPath: "/signing",
+		},
+	}
+	env := []v1.EnvVar{
+		{
+			Name:  "DOCKER_CONFIG",
+			Value: "/signing/.docker",
+		},
+	}
+	if registry.DockerConfigSecretRef != nil {
+		volumeMounts = append(volumeMounts, v1.VolumeMount{
+			Name:      "registry-docker-config",
+			MountPath: "/signing/.docker",
+			ReadOnly:  true,
+		})
+	}
+	if registry.CACertificateConfigMapKeyRef != nil {
+		volumeMounts = append(volumeMounts, v1.VolumeMount{
+			Name:      "registry-ca-certificate",
+			MountPath: "/signing/registry",
+			ReadOnly:  true,
+		})
+		env = append(env, v1.EnvVar{
+			Name:  "SSL_CERT_DIR",
+			Value: "/signing/registry",
+		})
+	}
+
+	syftEnv := append([]v1.EnvVar{}, env...)
+	if registryInsecure(registry) {
+		syftEnv = append(syftEnv, []v1.EnvVar{
+			{
+				Name:  "SYFT_REGISTRY_INSECURE_USE_HTTP",
+				Value: "true",
+			},
+			{
+				Name:  "SYFT_REGISTRY_INSECURE_SKIP_TLS_VERIFY",
+				Value: "true",
+			},
+		}...)
+	}
+
+	cosignArgs := []string{
+		"--yes",
+		"--key=env://COSIGN_PRIVATE_KEY",
+		fmt.Sprintf("--tlog-upload=%t", signing.TransparencyLog),
+	}
+	if registryInsecure(registry) {
+		cosignArgs = append(cosignArgs, "--allow-http-registry", "--allow-insecure-registry")
+	}
+	cosignEnv := append(append([]v1.EnvVar{}, env...), []v1.EnvVar{
+		{
+			Name: "COSIGN_PRIVATE_KEY",
+			ValueFrom: &coreV1.EnvVarSource{
+				SecretKeyRef: &coreV1.SecretKeySelector{
+					LocalObjectReference: signing.KeySecretRef,
+					Key:                  "cosign.key",
+				},
+			},
+		},
+		{
+			Name: "COSIGN_PASSWORD",
+			ValueFrom: &coreV1.EnvVarSource{
+				SecretKeyRef: &coreV1.SecretKeySelector{
+					LocalObjectReference: signing.KeySecretRef,
+					Key:                  "cosign.password",
+					Optional:             func(b bool) *bool { return &b }(true),
+				},
+			},
+		},
+	}...)
+
+	var imagePullSecrets []v1.LocalObjectReference
+	if registry.DockerConfigSecretRef != nil {
+		imagePullSecrets = append(imagePullSecrets, *registry.DockerConfigSecretRef)
+	}
+
+	return &batchV1.Job{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      signingJobName(runner, digest),
+			Namespace: runner.Namespace,
+			Labels: map[string]string{
+				"app": runner.Name + "-signing",
+			},
+		},
+		Spec: batchV1.JobSpec{
+			BackoffLimit: func(i int32) *int32 { return &i }(2),
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metaV1.ObjectMeta{
+					Labels: map[string]string{
+						"app": runner.Name + "-signing",
+					},
+				},
+				Spec: v1.PodSpec{
+					RestartPolicy: v1.RestartPolicyNever,
+					InitContainers: []v1.Container{
+						{
+							Name:            "sbom",
+							Image:           r.SyftImage,
+							ImagePullPolicy: v1.PullIfNotPresent,
+							Args: []string{
+								"scan",
+								fmt.Sprintf("registry:%s", reference),
+								fmt.Sprintf("--output=%s=%s", sbomFormat, sbomPath),
+							},
+							Env:                      syftEnv,
+							VolumeMounts:             volumeMounts,
+							TerminationMessagePath:   coreV1.TerminationMessagePathDefault,
+							TerminationMessagePolicy: coreV1.TerminationMessageFallbackToLogsOnError,
+						},
+						{
+							Name:            "attest",
+							Image:           r.CosignImage,
+							ImagePullPolicy: v1.PullIfNotPresent,
+							Args: append(append([]string{"attest"}, cosignArgs...),
+								fmt.Sprintf("--type=%s", predicateType),
+								fmt.Sprintf("--predicate=%s", sbomPath),
+								reference,
+							),
+							Env:                      cosignEnv,
+							VolumeMounts:             volumeMounts,
+							TerminationMessagePath:   coreV1.TerminationMessagePathDefault,
+							TerminationMessagePolicy: coreV1.TerminationMessageFallbackToLogsOnError,
+						},
+					},
+					Containers: []v1.Container{
+						{
+							Name:                     "sign",
+							Image:                    r.CosignImage,
+							ImagePullPolicy:          v1.PullIfNotPresent,
+							Args:                     append(append([]string{"sign"}, cosignArgs...), reference),
+							Env:                      cosignEnv,
+							VolumeMounts:             volumeMounts,
+							TerminationMessagePath:   coreV1.TerminationMessagePathDefault,
+							TerminationMessagePolicy: coreV1.TerminationMessageFallbackToLogsOnError,
+						},
+					},
+					Volumes:          volumes,
+					ImagePullSecrets: imagePullSecrets,
+					SecurityContext: &coreV1.PodSecurityContext{
+						SeccompProfile: &coreV1.SeccompProfile{
+							Type: coreV1.SeccompProfileTypeRuntimeDefault,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// reconcileSigning signs the digest of the runner image pushed by the builder by a signing job once it is built,
+// and records digests of the image, and of its signature and attestation stored by cosign next to it, once the job succeeds.
+// It returns whether the status changed.
+func (r *RunnerReconciler) reconcileSigning(ctx context.Context, runner *garV1.Runner) (bool, error) {
+	logger := r.Log.WithValues("runner", client.ObjectKeyFromObject(runner))
+
+	image := r.buildPullImage(runner)
+	if runner.Status.Image == image && runner.Status.SignatureDigest != "" {
+		return false, nil
+	}
+
+	// The tag is pushed again by every runner pod starting, so that it may already point to another build than the one to sign
+	digest, err := r.pushedImageDigest(ctx, runner, image)
+	if err != nil {
+		return false, err
+	}
+	if digest == "" {
+		return false, nil
+	}
+
+	registry := r.buildRegistry(runner)
+	registryClient, err := r.newRegistryClient(ctx, runner.Namespace, registry)
+	if err != nil {
+		return false, err
+	}
+	baseURL, prefix := r.registryURL(registryInsecure(registry))
+	repository := prefix + r.buildRepositoryName(runner)
+
+	expected := r.buildSigningJob(runner, registry, digest)
+	if err := r.cleanupSigningJobs(ctx, runner, expected.Name); err != nil {
+		return false, err
+	}
+	var job batchV1.Job
+	if err := r.Get(ctx, client.ObjectKeyFromObject(expected), &job); apierrors.IsNotFound(err) {
+		if err := controllerutil.SetControllerReference(runner, expected, r.Scheme); err != nil {
+			return false, err
+		}
+		if err := r.Create(ctx, expected); err != nil {
+			return false, err
+		}
+		r.Recorder.Eventf(runner, coreV1.EventTypeNormal, "SuccessfulCreated", "Created job: %q", expected.Name)
+		logger.V(1).Info("create", "job", expected)
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	var condition *metaV1.Condition
+	for _, c := range job.Status.Conditions {
+		if c.Status != coreV1.ConditionTrue {
+			continue
+		}
+		switch c.Type {
+		case batchV1.JobComplete:
+			tag := strings.Replace(digest, ":", "-", 1)
+			signatureDigest, err := registryClient.getManifestDigest(ctx, fmt.Sprintf("%s/v2/%s/manifests/%s.sig", baseURL, repository, tag))
+			if err != nil {
+				return false, xerrors.Errorf("failed to get digest of signature: %w", err)
+			}
+			attestationDigest, err := registryClient.getManifestDigest(ctx, fmt.Sprintf("%s/v2/%s/manifests/%s.att", baseURL, repository, tag))
+			if err != nil {
+				return false, xerrors.Errorf("failed to get digest of attestation: %w", err)
+			}
+			runner.Status.Image = image
+			runner.Status.ImageDigest = digest
+			runner.Status.SignatureDigest = signatureDigest
+			runner.Status.AttestationDigest = attestationDigest
+			condition = &metaV1.Condition{
+				Type:    conditionImageSigned,
+				Status:  metaV1.ConditionTrue,
+				Reason:  "SigningSucceeded",
+				Message: fmt.Sprintf("Signed %s@%s", image, digest),
+			}
+		case batchV1.JobFailed:
+			condition = &metaV1.Condition{
+				Type:    conditionImageSigned,
+				Status:  metaV1.ConditionFalse,
+				Reason:  "SigningFailed",
+				Message: truncateMessage(fmt.Sprintf("Job %q signing %s@%s failed: %s", job.Name, image, digest, c.Message), maxConditionMessage),
+			}
+		}
+	}
+	if condition == nil {
+		return false, nil
+	}
+
+	condition.ObservedGeneration = runner.Generation
+	if !meta.SetStatusCondition(&runner.Status.Conditions, *condition) && condition.Status == metaV1.ConditionFalse {
+		return false, nil
+	}
+	if condition.Status == metaV1.ConditionFalse {
+		r.Recorder.Eventf(runner, coreV1.EventTypeWarning, "FailedSign", "Failed to sign image: %s", condition.Message)
+	} else {
+		r.Recorder.Eventf(runner, coreV1.EventTypeNormal, "SuccessfulSigned", "Signed image %q: %s", runner.Status.Image, runner.Status.SignatureDigest)
+	}
+	return true, nil
+}
+
+// pushedImageDigest returns the digest of the runner image reported by the builder container which pushed the image last,
+// or an empty string if no builder of the image has reported it yet.
+func (r *RunnerReconciler) pushedImageDigest(ctx context.Context, runner *garV1.Runner, image string) (string, error) {
+	builder := r.builder(runner)
+	if builder == nil {
+		return "", nil
+	}
+	pods, err := r.listRunnerPods(ctx, runner)
+	if err != nil {
+		return "", err
+	}
+
+	var digest string
+	var finishedAt time.Time
+	for _, pod := range pods {
+		pod := pod
+		if !podRunsImage(&pod, image) {
+			continue
+		}
+		for _, status := range pod.Status.InitContainerStatuses {
+			terminated := status.State.Terminated
+			if status.Name != builder.name() || terminated == nil || terminated.ExitCode != 0 || terminated.FinishedAt.Time.Before(finishedAt) {
+				continue
+			}
+			if pushed := builder.pushedDigest(terminated.Message); pushed != "" {
+				digest, finishedAt = pushed, terminated.FinishedAt.Time
+			}
+		}
+	}
+	return digest, nil
+}
+
+// cleanupSigningJobs deletes jobs signing other digests of the runner image than the current one.
+func (r *RunnerReconciler) cleanupSigningJobs(ctx context.Context, runner *garV1.Runner, name string) error {
+	var jobs batchV1.JobList
+	if err := r.List(
+		ctx,
+		&jobs,
+		client.InNamespace(runner.Namespace),
+		client.MatchingLabels{"app": runner.Name + "-signing"},
+	); err != nil {
+		return err
+	}
+
+	for _, job := range jobs.Items {
+		job := job
+		if job.Name == name || !metaV1.IsControlledBy(&job, runner) {
+			continue
+		}
+		if err := r.Delete(ctx, &job, client.PropagationPolicy(metaV1.DeletePropagationBackground)); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		r.Recorder.Eventf(runner, coreV1.EventTypeNormal, "SuccessfulDeleted", "Deleted job: %q", job.Name)
+	}
+	return nil
+}
+
+// buildRunnerImage returns the image of the runner container, pinned to the signed digest once the runner image is signed,
+// so that runner pods run exactly the image whose signature is recorded.
+func (r *RunnerReconciler) buildRunnerImage(runner *garV1.Runner) string {
+	image := r.buildPullImage(runner)
+	if r.builder(runner) != nil && runner.Spec.Build.Signing != nil && runner.Status.Image == image && runner.Status.ImageDigest != "" && runner.Status.SignatureDigest != "" {
+		return fmt.Sprintf("%s@%s", image, runner.Status.ImageDigest)
+	}
+	return image
+}
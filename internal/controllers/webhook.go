@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	garV1 "github-actions-runner-controller/api/v1"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	repositoryKey      = ".spec.repository"
+	maxWebhookBodySize = 25 << 20
+	wokenAtAnnotation  = "github-actions-runner.kaidotio.github.io/wokenAt"
+)
+
+// webhookRunnable serves the webhook on every replica, not only on the leader, as GitHub delivers events to any replica behind the service.
+type webhookRunnable struct {
+	reconciler *RunnerReconciler
+}
+
+func (w webhookRunnable) Start(ctx context.Context) error {
+	return w.reconciler.serveWebhook(ctx)
+}
+
+func (webhookRunnable) NeedLeaderElection() bool {
+	return false
+}
+
+// serveWebhook receives workflow_job events of GitHub, and wakes up runners of the repository scaled by queued jobs without waiting for the next poll.
+// Runners are woken up by annotating them, so that the leader reconciles them whichever replica received the event.
+func (r *RunnerReconciler) serveWebhook(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/webhook", r.handleWebhook)
+	server := &http.Server{
+		Addr:              r.WebhookAddress,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		_ = server.Shutdown(context.Background())
+	}()
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (r *RunnerReconciler) handleWebhook(w http.ResponseWriter, req *http.Request) {
+	logger := r.Log.WithName("webhook")
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxWebhookBodySize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !validWebhookSignature(r.WebhookSecret, body, req.Header.Get("X-Hub-Signature-256")) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+	if req.Header.Get("X-GitHub-Event") != "workflow_job" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	payload := struct {
+		Action     string `json:"action"`
+		Repository struct {
+			FullName string `json:"full_name"`
+		} `json:"repository"`
+	}{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Queued jobs scale pools up, and completed jobs let idle pools scale down
+	if payload.Action != "queued" && payload.Action != "completed" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var runners garV1.RunnerList
+	if err := r.List(req.Context(), &runners, client.MatchingFields{repositoryKey: strings.ToLower(payload.Repository.FullName)}); err != nil {
+		logger.Error(err, "failed to list runners of repository", "repository", payload.Repository.FullName)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	wokenAt := time.Now().UTC().Format(time.RFC3339Nano)
+	for i := range runners.Items {
+		runner := &runners.Items[i]
+		if !hasPoolScaledByQueue(runner) {
+			continue
+		}
+
+		patch := []byte(`{"metadata":{"annotations":{"` + wokenAtAnnotation + `":"` + wokenAt + `"}}}`)
+		if err := r.Patch(req.Context(), runner, client.RawPatch(types.MergePatchType, patch)); err != nil {
+			// Runners are woken up by the next poll instead
+			logger.Error(err, "failed to wake up runner", "runner", client.ObjectKeyFromObject(runner))
+			continue
+		}
+		logger.V(1).Info("wake up", "runner", client.ObjectKeyFromObject(runner))
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func hasPoolScaledByQueue(runner *garV1.Runner) bool {
+	for i := range runner.Spec.Pools {
+		if scalesByQueue(&runner.Spec.Pools[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// validWebhookSignature verifies the HMAC-SHA256 signature of the body by the webhook secret given in X-Hub-Signature-256.
+func validWebhookSignature(secret string, body []byte, signature string) bool {
+	if !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
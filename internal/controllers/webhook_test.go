@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func TestValidWebhookSignature(t *testing.T) {
+	body := []byte(`{"action":"queued"}`)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(body)
+	digest := hex.EncodeToString(mac.Sum(nil))
+
+	type in struct {
+		body      []byte
+		signature string
+	}
+
+	type want struct {
+		valid bool
+	}
+
+	cases := []struct {
+		name string
+		in   in
+		want want
+	}{
+		{
+			"accepts the signature of the body",
+			in{
+				body,
+				"sha256=" + digest,
+			},
+			want{
+				true,
+			},
+		},
+		{
+			"rejects signatures without the sha256 prefix",
+			in{
+				body,
+				"sha1=" + digest,
+			},
+			want{
+				false,
+			},
+		},
+		{
+			"rejects signatures of hex in the wrong length",
+			in{
+				body,
+				"sha256=" + digest[:len(digest)-2],
+			},
+			want{
+				false,
+			},
+		},
+		{
+			"rejects signatures not in hex",
+			in{
+				body,
+				"sha256=" + digest[:len(digest)-1] + "z",
+			},
+			want{
+				false,
+			},
+		},
+		{
+			"rejects tampered bodies",
+			in{
+				[]byte(`{"action":"completed"}`),
+				"sha256=" + digest,
+			},
+			want{
+				false,
+			},
+		},
+		{
+			"rejects missing signatures",
+			in{
+				body,
+				"",
+			},
+			want{
+				false,
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := validWebhookSignature("secret", tc.in.body, tc.in.signature); got != tc.want.valid {
+				t.Errorf("valid: want %t, got %t", tc.want.valid, got)
+			}
+		})
+	}
+}
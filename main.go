@@ -6,13 +6,17 @@ import (
 	garV1 "github-actions-runner-controller/api/v1"
 	"github-actions-runner-controller/internal/controllers"
 	"os"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/klog/v2"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -41,14 +45,31 @@ func main() {
 	var pushRegistryHost string
 	var pullRegistryHost string
 	var enableRunnerMetrics bool
-	var exporterImage string
 	var githubAppClientId string
 	var githubAppInstallationId string
 	var githubAppPrivateKey string
+	var builder string
 	var kanikoImage string
+	var buildkitImage string
+	var syftImage string
+	var cosignImage string
 	var binaryVersion string
 	var runnerVersion string
+	var runnerVersionRefreshInterval time.Duration
+	var podDeletionCostInterval time.Duration
+	var deleteOrphanRunners bool
+	var queuePollInterval time.Duration
+	var webhookAddress string
+	var webhookSecret string
+	var runnerDownloadURL string
+	var binaryDownloadURL string
 	var disableupdate bool
+	var registryDockerConfigSecretName string
+	var registryInsecure bool
+	var registryCACertificateConfigMapName string
+	var registryGarbageCollectionInterval time.Duration
+	var registryGarbageCollectionRetention time.Duration
+	var registryGarbageCollectionConfigMap string
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.BoolVar(&secureMetrics, "metrics-secure", false, "If set the metrics endpoint is served securely")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false, "If set, HTTP/2 will be enabled for the metrics and webhook servers")
@@ -57,15 +78,32 @@ func main() {
 		"Enable leader election for controller manager.")
 	flag.StringVar(&pushRegistryHost, "push-registry-host", "ghcr.io/kaidotdev/github-actions-runner-controller", "Host of Docker Registry used as push destination.")
 	flag.StringVar(&pullRegistryHost, "pull-registry-host", "ghcr.io/kaidotdev/github-actions-runner-controller", "Host of Docker Registry used as pull source.")
-	flag.BoolVar(&enableRunnerMetrics, "enable-runner-metrics", false, "Enable to expose runner metrics using prometheus exporter.")
-	flag.StringVar(&exporterImage, "exporter-image", "ghcr.io/kaidotdev/github-actions-exporter/github-actions-exporter:v0.1.1", "Docker Image of exporter used by exporter container")
+	flag.BoolVar(&enableRunnerMetrics, "enable-runner-metrics", false, "Enable to expose metrics of jobs executed by runners on port 9090 of runner pods.")
 	flag.StringVar(&githubAppClientId, "github-app-client-id", "", "GitHub App Client ID")
 	flag.StringVar(&githubAppInstallationId, "github-app-installation-id", "", "GitHub App Installation ID")
 	flag.StringVar(&githubAppPrivateKey, "github-app-private-key", "", "GitHub App Private Key")
 	flag.StringVar(&kanikoImage, "kaniko-image", "gcr.io/kaniko-project/executor:v1.23.0", "Docker Image of kaniko used by builder container")
-	flag.StringVar(&binaryVersion, "binary-version", "0.4.5", "Version of own runner binary")
+	flag.StringVar(&builder, "builder", "kaniko", "Backend building runner images by default (kaniko, buildkit or none)")
+	flag.StringVar(&buildkitImage, "buildkit-image", "moby/buildkit:v0.16.0-rootless", "Docker Image of rootless buildkit used by builder container")
+	flag.StringVar(&syftImage, "syft-image", "anchore/syft:v1.14.0", "Docker Image of syft used to generate SBOM of runner images")
+	flag.StringVar(&cosignImage, "cosign-image", "gcr.io/projectsigstore/cosign:v2.4.1", "Docker Image of cosign used to sign runner images")
+	flag.StringVar(&binaryVersion, "binary-version", "0.5.0", "Version of own runner binary, which must be the same release as the controller for the flags it passes")
 	flag.StringVar(&runnerVersion, "runner-version", "2.321.0", "Version of GitHub Actions runner")
+	flag.StringVar(&runnerDownloadURL, "runner-download-url", "https://github.com/actions/runner/releases/download", "Base URL to download GitHub Actions runner from, such as a mirror for air-gapped clusters")
+	flag.StringVar(&binaryDownloadURL, "binary-download-url", "https://github.com/kaidotdev/github-actions-runner-controller/releases/download", "Base URL to download own runner binary from, such as a mirror for air-gapped clusters")
+	flag.DurationVar(&runnerVersionRefreshInterval, "runner-version-refresh-interval", time.Hour, "Interval to resolve the latest version of GitHub Actions runner for runners with runnerVersion: latest (resolved only once if 0)")
 	flag.BoolVar(&disableupdate, "disableupdate", false, "Disable self-hosted runner automatic update to the latest released version")
+	flag.StringVar(&registryDockerConfigSecretName, "registry-docker-config-secret-name", "", "Name of kubernetes.io/dockerconfigjson Secret in the runner's namespace used to push and pull runner images by default")
+	flag.BoolVar(&registryInsecure, "registry-insecure", false, "Push runner images over plain HTTP or without verifying the registry certificate")
+	flag.StringVar(&registryCACertificateConfigMapName, "registry-ca-certificate-config-map-name", "", "Name of ConfigMap in the runner's namespace holding the CA certificate of the registry as ca.crt by default")
+	flag.DurationVar(&registryGarbageCollectionInterval, "registry-garbage-collection-interval", 0, "Interval to delete runner images no longer referenced by any Runner from the push registry (disabled if 0)")
+	flag.DurationVar(&registryGarbageCollectionRetention, "registry-garbage-collection-retention", 24*time.Hour, "Period to keep runner images after they are no longer referenced by any Runner")
+	flag.StringVar(&registryGarbageCollectionConfigMap, "registry-garbage-collection-config-map", "", "ConfigMap (namespace/name) keeping the times runner images became unreferenced across restarts and leader changes of the controller (kept in memory if empty)")
+	flag.DurationVar(&podDeletionCostInterval, "pod-deletion-cost-interval", 0, "Interval to annotate runner pods with pod-deletion-cost following the busy state of their runners, so that idle runners are removed first on scale-down; every interval lists the runners of the repository of each Runner on the GitHub API (disabled if 0)")
+	flag.BoolVar(&deleteOrphanRunners, "delete-orphan-runners", false, "Remove offline runners left registered by runner pods which no longer exist every --pod-deletion-cost-interval (requires it to be set)")
+	flag.DurationVar(&queuePollInterval, "queue-poll-interval", 0, "Interval to poll queued jobs of GitHub for pools with minReplicas 0 (30s by default, or 5m with --webhook-address, whose events wake pools up between polls)")
+	flag.StringVar(&webhookAddress, "webhook-address", "", "Address receiving workflow_job events of GitHub at /webhook to wake up pools with minReplicas 0 without waiting for the next poll (disabled if empty)")
+	flag.StringVar(&webhookSecret, "webhook-secret", "", "Secret of the GitHub webhook verifying X-Hub-Signature-256, required with --webhook-address")
 	opts := zap.Options{}
 	opts.BindFlags(flag.CommandLine)
 	klog.InitFlags(flag.CommandLine)
@@ -113,23 +151,81 @@ func main() {
 		os.Exit(1)
 	}
 
+	if webhookAddress != "" && webhookSecret == "" {
+		entrypointLogger.Error(nil, "--webhook-secret is required with --webhook-address")
+		os.Exit(1)
+	}
+	var registryGarbageCollectionConfigMapKey types.NamespacedName
+	if registryGarbageCollectionConfigMap != "" {
+		namespace, name, ok := strings.Cut(registryGarbageCollectionConfigMap, "/")
+		if !ok || namespace == "" || name == "" {
+			entrypointLogger.Error(nil, "--registry-garbage-collection-config-map must be namespace/name")
+			os.Exit(1)
+		}
+		registryGarbageCollectionConfigMapKey = types.NamespacedName{Namespace: namespace, Name: name}
+	}
+	if queuePollInterval == 0 {
+		queuePollInterval = 30 * time.Second
+		if webhookAddress != "" {
+			queuePollInterval = 5 * time.Minute
+		}
+	}
+
+	kubernetesClient, err := kubernetes.NewForConfig(m.GetConfig())
+	if err != nil {
+		entrypointLogger.Error(err, "unable to create kubernetes client")
+		os.Exit(1)
+	}
+
 	if err := (&controllers.RunnerReconciler{
+		Client:                             m.GetClient(),
+		KubernetesClient:                   kubernetesClient,
+		Scheme:                             m.GetScheme(),
+		Log:                                ctrl.Log.WithName("controllers").WithName("Runner"),
+		Recorder:                           m.GetEventRecorderFor("github-actions-runner-controller"),
+		PushRegistryHost:                   pushRegistryHost,
+		PullRegistryHost:                   pullRegistryHost,
+		EnableRunnerMetrics:                enableRunnerMetrics,
+		GitHubAppClientId:                  githubAppClientId,
+		GitHubAppInstallationId:            githubAppInstallationId,
+		GitHubAppPrivateKey:                githubAppPrivateKey,
+		Builder:                            builder,
+		KanikoImage:                        kanikoImage,
+		BuildKitImage:                      buildkitImage,
+		SyftImage:                          syftImage,
+		CosignImage:                        cosignImage,
+		BinaryVersion:                      binaryVersion,
+		RunnerVersion:                      runnerVersion,
+		RunnerDownloadURL:                  runnerDownloadURL,
+		BinaryDownloadURL:                  binaryDownloadURL,
+		Disableupdate:                      disableupdate,
+		RegistryDockerConfigSecretName:     registryDockerConfigSecretName,
+		RegistryInsecure:                   registryInsecure,
+		RegistryCACertificateConfigMapName: registryCACertificateConfigMapName,
+		RegistryGarbageCollectionInterval:  registryGarbageCollectionInterval,
+		RegistryGarbageCollectionRetention: registryGarbageCollectionRetention,
+		RegistryGarbageCollectionConfigMap: registryGarbageCollectionConfigMapKey,
+		RunnerVersionRefreshInterval:       runnerVersionRefreshInterval,
+		PodDeletionCostInterval:            podDeletionCostInterval,
+		DeleteOrphanRunners:                deleteOrphanRunners,
+		QueuePollInterval:                  queuePollInterval,
+		WebhookAddress:                     webhookAddress,
+		WebhookSecret:                      webhookSecret,
+	}).SetupWithManager(m); err != nil {
+		entrypointLogger.Error(err, "unable to create controller", "controller", "Runner")
+		os.Exit(1)
+	}
+
+	if err := (&controllers.RunnerSetReconciler{
 		Client:                  m.GetClient(),
 		Scheme:                  m.GetScheme(),
-		Log:                     ctrl.Log.WithName("controllers").WithName("Runner"),
+		Log:                     ctrl.Log.WithName("controllers").WithName("RunnerSet"),
 		Recorder:                m.GetEventRecorderFor("github-actions-runner-controller"),
-		PushRegistryHost:        pushRegistryHost,
-		PullRegistryHost:        pullRegistryHost,
-		EnableRunnerMetrics:     enableRunnerMetrics,
-		ExporterImage:           exporterImage,
 		GitHubAppClientId:       githubAppClientId,
 		GitHubAppInstallationId: githubAppInstallationId,
-		GitHubAppPrivateKey:     githubAppPrivateKey, KanikoImage: kanikoImage,
-		BinaryVersion: binaryVersion,
-		RunnerVersion: runnerVersion,
-		Disableupdate: disableupdate,
+		GitHubAppPrivateKey:     githubAppPrivateKey,
 	}).SetupWithManager(m); err != nil {
-		entrypointLogger.Error(err, "unable to create controller", "controller", "Runner")
+		entrypointLogger.Error(err, "unable to create controller", "controller", "RunnerSet")
 		os.Exit(1)
 	}
 